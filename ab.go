@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// abRun is one side of cpu ab, a cpu run with the flags that choose
+// where the command goes.
+type abRun struct {
+	name   string
+	flags  []string
+	code   int
+	took   time.Duration
+	output bytes.Buffer
+}
+
+func (r *abRun) run(self string, args []string) {
+	cmd := exec.Command(self, append(append(passedFlags(), r.flags...), args...)...)
+	cmd.Stdout, cmd.Stderr = &r.output, &r.output
+	explainf("executing %s", cmd)
+	start := time.Now()
+	err := cmd.Run()
+	r.took = time.Since(start)
+	var exiterr *exec.ExitError
+	if errors.As(err, &exiterr) {
+		r.code = exiterr.ExitCode()
+	} else if err != nil {
+		r.code = -1
+		fmt.Fprintf(&r.output, "%s: %v\n", os.Args[0], err)
+	}
+}
+
+// Writes a unified diff of the outputs of a and b with diff(1).
+func diffOutputs(a, b *abRun) error {
+	var names []string
+	for _, r := range []*abRun{a, b} {
+		f, err := os.CreateTemp("", "cpu-ab-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		f.Write(r.output.Bytes())
+		f.Close()
+		names = append(names, f.Name())
+	}
+	cmd := exec.Command("diff", "-u", "--label", a.name, "--label", b.name, names[0], names[1])
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exiterr *exec.ExitError
+		if !errors.As(err, &exiterr) || exiterr.ExitCode() != 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+// ab runs the command here and on the remote at the same time, and
+// compares their exit statuses, how long they took and their output,
+// to tell whether the remote can be trusted to behave as this machine
+// does.  It exits 1 when they differ, like diff(1).
+func ab(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("ab", flag.ExitOnError)
+	output := fs.Bool("output", false, "show how the outputs differ, not only whether they do")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s ab [-output] command [argument ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EX_USAGE
+	}
+	self, err := os.Executable()
+	if err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	target := login
+	if remotePort != "" {
+		target += "#" + remotePort
+	}
+	target += ":" + path
+
+	a := &abRun{name: "local", flags: []string{"-local"}}
+	b := &abRun{name: login, flags: []string{"-r", target}}
+	var wg sync.WaitGroup
+	for _, r := range []*abRun{a, b} {
+		wg.Add(1)
+		go func(r *abRun) {
+			defer wg.Done()
+			r.run(self, fs.Args())
+		}(r)
+	}
+	wg.Wait()
+
+	same := bytes.Equal(a.output.Bytes(), b.output.Bytes())
+	outcome := map[bool]string{true: "same", false: "differs"}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "\t%s\t%s\t\n", a.name, b.name)
+	fmt.Fprintf(tw, "status\t%d\t%d\t%s\n", a.code, b.code, outcome[a.code == b.code])
+	fmt.Fprintf(tw, "time\t%s\t%s\t\n", a.took.Round(time.Millisecond), b.took.Round(time.Millisecond))
+	fmt.Fprintf(tw, "output\t%d bytes\t%d bytes\t%s\n", a.output.Len(), b.output.Len(), outcome[same])
+	tw.Flush()
+	if *output && !same {
+		fmt.Println()
+		if err := diffOutputs(a, b); err != nil {
+			exit(EX_CMDNFOUND, "ab: %v", err)
+		}
+	}
+	if a.code != b.code || !same {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var anomaly = flag.String("anomaly", "none",
+	"`action` on runs taking much longer or writing much more than usual: warn, notify, kill or none")
+
+const (
+	// Number of successful runs needed before runs are compared to
+	// them.
+	anomalyRuns = 3
+
+	// How many times longer than usual a run has to take, and how
+	// much more output it has to write, to be reported.
+	durationFactor = 3
+	outputFactor   = 10
+)
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+func (c *byteCounter) count() int64 { return atomic.LoadInt64(&c.n) }
+
+func (c *byteCounter) reset() { atomic.StoreInt64(&c.n, 0) }
+
+// Bytes written by the command to its standard output and error.
+var outputSize byteCounter
+
+// baseline is what a run of a command usually looks like.
+type baseline struct {
+	duration time.Duration
+	output   int64 // or -1 if unknown
+	runs     int
+}
+
+// Baseline of the runs being compared against, set before the command
+// is run.
+var usual baseline
+
+// Returns the median duration and output of the last successful runs
+// of command on login in dir.
+func usualRun(login string, dir string, command string) baseline {
+	runs := pastRuns(login, dir, command)
+	if len(runs) == 0 {
+		return baseline{output: -1}
+	}
+	var ds []time.Duration
+	var outputs []int64
+	for _, r := range runs {
+		ds = append(ds, r.duration)
+		if r.output >= 0 {
+			outputs = append(outputs, r.output)
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	b := baseline{duration: ds[len(ds)/2], output: -1, runs: len(runs)}
+	if len(outputs) > 0 {
+		sort.Slice(outputs, func(i, j int) bool { return outputs[i] < outputs[j] })
+		b.output = outputs[len(outputs)/2]
+	}
+	return b
+}
+
+// Watches cmd, the ssh(1) running command on login, for running much
+// longer or writing much more than usual, and acts on it according to
+// -anomaly.  Runs with a pseudo-terminal are left alone, as how long an
+// interactive session lasts says nothing about whether it has hung.
+// The returned function stops watching and reports whether cmd was
+// killed.
+func watchAnomalies(login string, command []string, cmd *exec.Cmd) func() bool {
+	switch *anomaly {
+	case "warn", "notify", "kill", "none":
+	default:
+		exit(EX_USAGE, "-anomaly must be warn, notify, kill, or none")
+	}
+	if *anomaly == "none" || wantTTY() || usual.runs < anomalyRuns {
+		return func() bool { return false }
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	var killed int32
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		slow, loud := false, false
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			elapsed, n := time.Since(start), outputSize.count()
+			var reason string
+			switch {
+			case !slow && elapsed > durationFactor*usual.duration && elapsed-usual.duration > time.Minute:
+				slow = true
+				reason = fmt.Sprintf("has run for %v, usually %v, and may have hung",
+					elapsed.Round(time.Second), usual.duration.Round(time.Second))
+			case !loud && usual.output >= 0 && n > outputFactor*usual.output && n-usual.output > 1<<20:
+				loud = true
+				reason = fmt.Sprintf("has written %d bytes, usually %d, and may be stuck in a loop",
+					n, usual.output)
+			default:
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s: command %s\n", os.Args[0], reason)
+			switch *anomaly {
+			case "notify":
+				sendNotification(login, command, -1, elapsed, reason)
+			case "kill":
+				atomic.StoreInt32(&killed, 1)
+				cmd.Process.Kill()
+				return
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		return atomic.LoadInt32(&killed) == 1
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Asks for approval to run cmd on remote when its host in the
+// -inventory sets cpu_approve, as the hosts of a production group may
+// in [production:vars].  With cpu_approve=ask the command has to be
+// confirmed on the terminal.  Any other value is a local command, such
+// as one asking for approval over a webhook or chat, that is given the
+// host in CPU_HOST and the command in CPU_COMMAND and approves by
+// exiting zero.  A command that is not approved is refused as the
+// remote's policy would.
+func approveRun(remote, cmd string) {
+	inv := loadInventory()
+	if inv == nil {
+		return
+	}
+	host, ok := inv.findHost(remote)
+	if !ok {
+		return
+	}
+	switch approve := inv.lookup(host, "cpu_approve"); approve {
+	case "":
+	case "ask":
+		if !confirmRun(host, cmd) {
+			exit(policyRefused, "running on %s was not confirmed", host)
+		}
+	default:
+		explainf("asking %q for approval to run on %s", approve, host)
+		c := exec.Command("sh", "-c", approve)
+		c.Env = append(os.Environ(), "CPU_HOST="+host, "CPU_COMMAND="+cmd)
+		c.Stdout, c.Stderr = os.Stderr, os.Stderr
+		if err := c.Run(); err != nil {
+			exit(policyRefused, "running on %s was not approved by %s: %v", host, approve, err)
+		}
+	}
+}
+
+func confirmRun(host, cmd string) bool {
+	tty, err := os.Open(consoleName)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(os.Stderr, "run %s on %s? [y/N] ", cmd, colorHost(host, os.Stderr))
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var capture = flag.String("capture", "",
+	"record configuration, argv, output, and timings of the run into this tar file")
+
+// The run being recorded by -capture, or nil.
+var bugReport *report
+
+// Environment variables whose values are redacted from reports.
+var secretName = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSWD|CREDENTIAL|_KEY$)`)
+
+// Flags whose values are redacted from reports besides those named
+// like secrets, as the webhook URLs of -notify carry their tokens.
+var secretFlags = []string{"notify"}
+
+// A report collects what is needed to reproduce a run of cpu.
+// Its methods may be called on a nil report, in which case they
+// do nothing.
+type report struct {
+	name    string
+	start   time.Time
+	secrets []string
+	config  bytes.Buffer
+	argv    bytes.Buffer
+	environ bytes.Buffer
+	timings bytes.Buffer
+	stdout  bytes.Buffer
+	stderr  bytes.Buffer
+}
+
+func newReport(name string) *report {
+	r := &report{name: name, start: time.Now()}
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value != "" && (secretName.MatchString(f.Name) || contains(secretFlags, f.Name)) {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(&r.config, "-%s=%s\n", f.Name, value)
+	})
+	fmt.Fprintf(&r.config, "args=%q\n", flag.Args())
+	for _, line := range versionInfo() {
+		fmt.Fprintf(&r.config, "# %s\n", line)
+	}
+	for _, kv := range append(os.Environ(), envFileVars...) {
+		// the values of secrets are redacted wherever they appear,
+		// as in the assignments of -env-file in the remote command
+		if name, v, _ := strings.Cut(kv, "="); secretName.MatchString(name) && len(v) >= 4 {
+			r.secrets = append(r.secrets, v, strings.Trim(strconv.Quote(v), `"`))
+		}
+	}
+	for _, kv := range os.Environ() {
+		name := kv[:strings.Index(kv, "=")]
+		if !strings.HasPrefix(name, "CPU_") && envReasons[name] == "" && name != "SHELL" {
+			continue
+		}
+		if secretName.MatchString(name) {
+			kv = name + "=<redacted>"
+		}
+		fmt.Fprintln(&r.environ, kv)
+	}
+	r.mark("start")
+	return r
+}
+
+// Notes the elapsed time at which event happened.
+// At -vvv the time is also logged.
+func (r *report) mark(event string) {
+	debugf(logTimings, "timing", "%s after %v", event, time.Since(startTime))
+	if event != "start" {
+		// the report starting is not a phase of the run
+		endPhase(event)
+	}
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(&r.timings, "%-12s %v\n", event, time.Since(r.start))
+}
+
+// Records a resolved setting that is not a flag.
+func (r *report) set(key string, value string) {
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(&r.config, "%s=%s\n", key, value)
+}
+
+// Records the command line of a program executed on the user's behalf.
+func (r *report) exec(args []string) {
+	if r == nil {
+		return
+	}
+	for _, arg := range args {
+		fmt.Fprintln(&r.argv, strconv.Quote(arg))
+	}
+	fmt.Fprintln(&r.argv)
+}
+
+// Returns writers that copy to stdout and stderr and into the report.
+func (r *report) tee(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if r == nil {
+		return stdout, stderr
+	}
+	return io.MultiWriter(stdout, &r.stdout), io.MultiWriter(stderr, &r.stderr)
+}
+
+// Writes the report to its tar file, replacing the values of secrets,
+// and the user's name and home directory, with placeholders.
+func (r *report) write() error {
+	if r == nil {
+		return nil
+	}
+	r.mark("end")
+
+	var pairs []string
+	for _, s := range r.secrets {
+		pairs = append(pairs, s, "<redacted>")
+	}
+	if usr, err := user.Current(); err == nil {
+		pairs = append(pairs, usr.HomeDir, "~", usr.Username, "$USER")
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	f, err := os.Create(r.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	entries := []struct {
+		name string
+		buf  *bytes.Buffer
+	}{
+		{"config.txt", &r.config},
+		{"argv.txt", &r.argv},
+		{"environ.txt", &r.environ},
+		{"timings.txt", &r.timings},
+		{"stdout.txt", &r.stdout},
+		{"stderr.txt", &r.stderr},
+	}
+	for _, e := range entries {
+		data := []byte(replacer.Replace(e.buf.String()))
+		hdr := &tar.Header{
+			Name:    "bugreport/" + e.name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: r.start,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
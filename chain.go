@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// stage is the part of a chained command run on one remote.
+type stage struct {
+	login, port, path string
+	args              []string
+}
+
+// Splits the command at -then into the command for the remote given
+// with -r and the next stage, which has its own -r.  The returned
+// stage is nil if the command is not chained.
+func splitChain(args []string) ([]string, *stage) {
+	for i, arg := range args {
+		if arg != "-then" && arg != "--then" {
+			continue
+		}
+		fs := flag.NewFlagSet("then", flag.ExitOnError)
+		next := fs.String("r", "", "remote compute machine to pipe the output to")
+		fs.Parse(args[i+1:])
+		if *next == "" || fs.NArg() == 0 {
+			exit(EX_USAGE, "-then needs a remote and a command")
+		}
+		s := &stage{args: fs.Args()}
+		s.login, s.port, s.path = splitLoginPath(*next)
+		return args[:i], s
+	}
+	return args, nil
+}
+
+// Connects to the remote of s and returns the ssh arguments for it.
+// The port is swapped in since makeSshOptions otherwise uses the port
+// of the first remote.
+func (s *stage) connect() []string {
+	saved := remotePort
+	remotePort = s.port
+	defer func() { remotePort = saved }()
+	setupConnectionSharing(s.login)
+	return makeSshArgs(s.login)
+}
+
+// Runs args on login and pipes its output to the command of next.
+// When login can reach the next remote on its own, the output goes
+// straight there; otherwise it is relayed through this machine.  As
+// in a shell pipeline, the exit status is that of the last command.
+func chain(login string, path string, args []string, next *stage) int {
+	if *dryRun {
+		exit(EX_USAGE, "-n cannot be used with -then")
+	}
+	// a pseudo-terminal would mangle the output passed along
+	compatTTY = "-T"
+
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	nextArgs, err := expandLocal(next.args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	path, next.path = rcpu.MapPath(path), rcpu.MapPath(next.path)
+	nextCmd := makeRemoteCmd(next.path, nextArgs)
+
+	hop := []string{"ssh", "-o", "BatchMode=yes", "-e", "none", "-T"}
+	if next.port != "" {
+		hop = append(hop, "-p", next.port)
+	}
+	hop = append(hop, next.login)
+	probe := exec.Command("ssh", append(makeSshArgs(login), strings.Join(hop, " ")+" true")...)
+	if probe.Run() == nil {
+		explainf("%s can reach %s, so piping the output there directly", login, next.login)
+		direct := fmt.Sprintf("%s | %s %s", makeRemoteCmd(path, args), strings.Join(hop, " "), rcpu.Quote(nextCmd))
+		return runCommand(exec.Command("ssh", append(makeSshArgs(login), direct)...))
+	}
+
+	explainf("%s cannot reach %s on its own, so relaying the output through this machine", login, next.login)
+	first := exec.Command("ssh", append(makeSshArgs(login), makeRemoteCmd(path, args))...)
+	second := exec.Command("ssh", append(next.connect(), nextCmd)...)
+	r, w := io.Pipe()
+	first.Stdin, first.Stdout, first.Stderr = os.Stdin, w, os.Stderr
+	second.Stdin, second.Stdout, second.Stderr = r, os.Stdout, os.Stderr
+	explainf("executing %s | %s", first, second)
+	if err := first.Start(); err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	go func() {
+		first.Wait()
+		w.Close()
+	}()
+	if err := second.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode()
+		}
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	return 0
+}
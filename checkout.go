@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Parent of the worktrees made by cpu checkout on the remote.
+const worktreeRoot = cacheRoot + "/checkout"
+
+var (
+	pullRequest  = regexp.MustCompile(`^(?:#|pr/|pull/)?([0-9]+)$`)
+	gerritChange = regexp.MustCompile(`^(?:change/)?([0-9]+)/([0-9]+)$`)
+)
+
+// Returns the ref of a review and a name for it.  A GitHub pull request
+// is given by its number, optionally as #123 or pr/123, and a Gerrit
+// change by its number and patch set, as in 4567/2.  Anything else is
+// taken to be a ref.
+func reviewRef(review string) (ref string, name string) {
+	if m := pullRequest.FindStringSubmatch(review); m != nil {
+		return "refs/pull/" + m[1] + "/head", "pr-" + m[1]
+	}
+	if m := gerritChange.FindStringSubmatch(review); m != nil {
+		change := m[1]
+		shard := change
+		if len(shard) > 2 {
+			shard = shard[len(shard)-2:]
+		} else if len(shard) < 2 {
+			shard = "0" + shard
+		}
+		return fmt.Sprintf("refs/changes/%s/%s/%s", shard, change, m[2]), "change-" + change + "-" + m[2]
+	}
+	return review, strings.NewReplacer("/", "-", ":", "-").Replace(strings.TrimPrefix(review, "refs/"))
+}
+
+// checkout fetches a review into the remote checkout, adds a worktree
+// for it, and makes the worktree the remote directory for later
+// commands from the local directory.
+func checkout(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("checkout", flag.ExitOnError)
+	local := fs.Bool("local", false, "also fetch the review into the local repository as cpu/<name>")
+	reset := fs.Bool("clear", false, "go back to running in the mapped checkout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s checkout [-local] review | -clear\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	cwd, _ := os.Getwd()
+	if *reset {
+		setCheckoutTarget(login, cwd, "")
+		return 0
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	ref, name := reviewRef(fs.Arg(0))
+	repo := rcpu.MapPath(path)
+	wt := fmt.Sprintf("%s/%s-%s", worktreeRoot, filepath.Base(cwd), name)
+	add := fmt.Sprintf(`cd %s && git fetch --quiet origin %s && mkdir -p %s && `+
+		`{ git worktree remove --force %s 2>/dev/null; git worktree add --quiet --detach %s FETCH_HEAD; }`,
+		repo, rcpu.Quote(ref), worktreeRoot, wt, wt)
+	explainf("fetching %s into %s:%s and checking it out in %s", ref, login, repo, wt)
+	if code := runCommand(exec.Command("ssh", append(makeSshArgs(login), add)...)); code != 0 {
+		return code
+	}
+
+	if *local {
+		fetch := exec.Command("git", "fetch", "--quiet", "origin", "+"+ref+":cpu/"+name)
+		fetch.Stdout, fetch.Stderr = os.Stdout, os.Stderr
+		if err := fetch.Run(); err != nil {
+			exit(EX_SYNC, "fetching %s locally: %v", ref, err)
+		}
+	}
+
+	setCheckoutTarget(login, cwd, wt)
+	fmt.Fprintf(os.Stderr, "%s: commands from %s now run in %s\n", os.Args[0], cwd, rcpu.HostPath(login, wt))
+	return 0
+}
+
+// File mapping local directories to the remote worktree commands run
+// in, one tab-separated login, local directory and worktree per line.
+func checkoutFile() string {
+	return stateFile("checkout")
+}
+
+// Returns the worktree made by cpu checkout on login for the local
+// directory dir, or "".
+func checkoutTarget(login string, dir string) string {
+	data, _ := os.ReadFile(checkoutFile())
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 3 && fields[0] == login && fields[1] == dir {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+// Sets or, if wt is "", removes the worktree for dir on login.
+func setCheckoutTarget(login string, dir string, wt string) {
+	data, _ := os.ReadFile(checkoutFile())
+	var b strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[0] == login && fields[1] == dir {
+			continue
+		}
+		fmt.Fprintln(&b, line)
+	}
+	if wt != "" {
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", login, dir, wt)
+	}
+	name := checkoutFile()
+	os.MkdirAll(filepath.Dir(name), 0700)
+	os.WriteFile(name, []byte(b.String()), 0600)
+}
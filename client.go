@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"os"
+	"os/user"
+	"runtime"
+)
+
+var clientEnv = flag.Bool("client-env", os.Getenv("CPU_CLIENT_ENV") != "",
+	"tell the remote command the local operating system, directory and user name in CPU_CLIENT_* variables")
+
+// Identifies this invocation of cpu to the remote command.
+var sessionID = newSessionID()
+
+func newSessionID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// Returns the variables telling the remote command about the client
+// it was run from, so that scripts can adapt to it or label artifacts
+// with where they came from, if -client-env asks for them.
+func clientVars() []string {
+	if !*clientEnv {
+		return nil
+	}
+	cwd, _ := os.Getwd()
+	name := os.Getenv("USER")
+	if usr, err := user.Current(); err == nil {
+		name = usr.Username
+	}
+	return []string{
+		"CPU_CLIENT_OS=" + runtime.GOOS,
+		"CPU_CLIENT_CWD=" + cwd,
+		"CPU_LOCAL_USER=" + name,
+		"CPU_SESSION_ID=" + sessionID,
+	}
+}
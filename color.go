@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Colours hosts are given by default, as SGR parameters.  Red is left
+// out, as it marks failures.
+var hostPalette = []string{"32", "33", "34", "35", "36", "92", "93", "94", "95", "96"}
+
+// Colours that may be given to hosts by name.
+var colorNames = map[string]string{
+	"black": "30", "red": "31", "green": "32", "yellow": "33",
+	"blue": "34", "magenta": "35", "cyan": "36", "white": "37",
+	"bright-black": "90", "bright-red": "91", "bright-green": "92", "bright-yellow": "93",
+	"bright-blue": "94", "bright-magenta": "95", "bright-cyan": "96", "bright-white": "97",
+}
+
+// File giving hosts colours of their own, one per line:
+//
+//	host colour
+//
+// where colour is a name such as blue or bright-cyan, or a number of
+// the 256-colour palette.
+func colorsFile() string {
+	return configFile("colors")
+}
+
+// Returns the colour of the host of login as SGR parameters: the one
+// in the colours file, or else one of the palette picked by a hash of
+// the host name, so that it stays the same between runs.
+func hostColor(login string) string {
+	host := hostOf(login)
+	if f, err := os.Open(colorsFile()); err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) != 2 || fields[0] != host && fields[0] != login {
+				continue
+			}
+			if sgr, ok := colorNames[fields[1]]; ok {
+				return sgr
+			}
+			if n, err := strconv.Atoi(fields[1]); err == nil && n >= 0 && n < 256 {
+				return "38;5;" + fields[1]
+			}
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return hostPalette[h.Sum32()%uint32(len(hostPalette))]
+}
+
+// Returns login in its colour if f is a terminal and NO_COLOR is not
+// set, for messages naming a remote.
+func colorHost(login string, f *os.File) string {
+	if os.Getenv("NO_COLOR") != "" || !isatty(f) {
+		return login
+	}
+	return "\x1b[" + hostColor(login) + "m" + login + "\x1b[0m"
+}
+
+// Returns the colour of login as a number of the 256-colour palette,
+// for tmux(1) styles and prompts.
+func hostColorNumber(login string) int {
+	sgr := hostColor(login)
+	if n, ok := strings.CutPrefix(sgr, "38;5;"); ok {
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	n, _ := strconv.Atoi(sgr)
+	if n >= 90 {
+		return n - 90 + 8
+	}
+	return n - 30
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// ssh(1) options taking an argument.
+const sshArgOptions = "BbcDEeFIiJLlmOopQRSWw"
+
+// ssh(1) options without an argument.
+const sshBoolOptions = "46AaCfGgKkMNnqsTtVvXxYy"
+
+var (
+	// Options given in -ssh-compat mode, passed on to ssh.
+	compatSshArgs []string
+
+	// Pseudo-terminal request made in -ssh-compat mode:
+	// "-t" to force one, "-T" to disable it, or "" to decide as usual.
+	compatTTY string
+)
+
+// Reports whether cpu was asked to behave like ssh(1), either by
+// being invoked as cpus or with -ssh-compat as the first argument.
+func compatMode() bool {
+	return invokedAsCpus() || firstArg("ssh-compat")
+}
+
+func invokedAsCpus() bool {
+	return path.Base(os.Args[0]) == "cpus"
+}
+
+// Reports whether the first argument is the flag name, with one or
+// two leading dashes.
+func firstArg(name string) bool {
+	return len(os.Args) > 1 && (os.Args[1] == "-"+name || os.Args[1] == "--"+name)
+}
+
+// Parses ssh(1) style arguments, [options] destination [command ...],
+// setting the remote and returning the command.  Without a command,
+// an interactive login shell is started in the mapped directory.
+func parseSshArgs(args []string) []string {
+	if len(args) > 0 && !invokedAsCpus() {
+		switch args[0] {
+		case "-ssh-compat", "--ssh-compat", "-transport-only", "--transport-only":
+			args = args[1:]
+		}
+	}
+
+	var user string
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for i := 1; i < len(arg); i++ {
+			opt := arg[i]
+			switch {
+			case strings.IndexByte(sshBoolOptions, opt) >= 0:
+				switch opt {
+				case 't', 'T':
+					compatTTY = "-" + string(opt)
+				default:
+					compatSshArgs = append(compatSshArgs, "-"+string(opt))
+				}
+			case strings.IndexByte(sshArgOptions, opt) >= 0:
+				val := arg[i+1:]
+				if val == "" {
+					if len(args) == 0 {
+						exit(EX_USAGE, "option requires an argument -- %c", opt)
+					}
+					val, args = args[0], args[1:]
+				}
+				if opt == 'l' {
+					user = val
+				} else {
+					compatSshArgs = append(compatSshArgs, "-"+string(opt), val)
+				}
+				i = len(arg)
+			default:
+				exit(EX_USAGE, "unknown option -- %c", opt)
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		exit(EX_USAGE, "missing destination")
+	}
+	*remote = args[0]
+	if user != "" && !strings.Contains(*remote, "@") {
+		*remote = user + "@" + *remote
+	}
+	if len(args) == 1 {
+		return []string{"exec", "$SHELL", "-l"}
+	}
+	return args[1:]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSshArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		remote  string
+		command []string
+		sshArgs []string
+		tty     string
+	}{
+		{
+			args:    []string{"-p", "2222", "host", "ls"},
+			remote:  "host",
+			command: []string{"ls"},
+			sshArgs: []string{"-p", "2222"},
+		},
+		{
+			args:    []string{"-p2222", "host", "ls", "-l"},
+			remote:  "host",
+			command: []string{"ls", "-l"},
+			sshArgs: []string{"-p", "2222"},
+		},
+		{
+			args:    []string{"-l", "ato", "localhost", "ls"},
+			remote:  "ato@localhost",
+			command: []string{"ls"},
+		},
+		{
+			args:    []string{"-l", "ato", "root@localhost", "ls"},
+			remote:  "root@localhost",
+			command: []string{"ls"},
+		},
+		{
+			args:    []string{"-tqC", "host", "top"},
+			remote:  "host",
+			command: []string{"top"},
+			sshArgs: []string{"-q", "-C"},
+			tty:     "-t",
+		},
+		{
+			args:    []string{"-T", "-o", "BatchMode=yes", "host", "true"},
+			remote:  "host",
+			command: []string{"true"},
+			sshArgs: []string{"-o", "BatchMode=yes"},
+			tty:     "-T",
+		},
+		{
+			args:    []string{"-v", "--", "host", "-x"},
+			remote:  "host",
+			command: []string{"-x"},
+			sshArgs: []string{"-v"},
+		},
+		{
+			args:    []string{"-ssh-compat", "-p", "2222", "host", "ls"},
+			remote:  "host",
+			command: []string{"ls"},
+			sshArgs: []string{"-p", "2222"},
+		},
+		{
+			args:    []string{"--transport-only", "host", "rsync", "--server"},
+			remote:  "host",
+			command: []string{"rsync", "--server"},
+		},
+		{
+			args:    []string{"host"},
+			remote:  "host",
+			command: []string{"exec", "$SHELL", "-l"},
+		},
+	}
+	for _, tt := range tests {
+		*remote, compatSshArgs, compatTTY = "", nil, ""
+		command := parseSshArgs(tt.args)
+		if *remote != tt.remote || !reflect.DeepEqual(command, tt.command) ||
+			!reflect.DeepEqual(compatSshArgs, tt.sshArgs) || compatTTY != tt.tty {
+			t.Errorf("parseSshArgs(%q) = %q, %q, %q, %q; want %q, %q, %q, %q",
+				tt.args, *remote, command, compatSshArgs, compatTTY,
+				tt.remote, tt.command, tt.sshArgs, tt.tty)
+		}
+	}
+}
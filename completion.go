@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func init() {
+	// added here, as completion lists the subcommands itself
+	fleetSubcommands["completion"] = completion
+}
+
+// Completes the flags, subcommands and local commands for cpu, and the
+// remotes for -r by running cpu completion -remotes each time, so that
+// newly configured hosts are offered without reloading the script.
+const bashCompletion = `_cpu() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	case $prev in
+	-r|--r) COMPREPLY=($(compgen -W "$(cpu completion -remotes 2>/dev/null)" -- "$cur")); return;;
+	esac
+	case $cur in
+	-*) COMPREPLY=($(compgen -W "%s" -- "$cur"));;
+	*) COMPREPLY=($(compgen -W "%s" -- "$cur") $(compgen -c -- "$cur"));;
+	esac
+}
+complete -o default -F _cpu cpu
+`
+
+const zshCompletion = `#compdef cpu
+_cpu() {
+	if [[ $words[CURRENT-1] == -r ]]; then
+		compadd -- ${(f)"$(cpu completion -remotes 2>/dev/null)"}
+	elif [[ $PREFIX == -* ]]; then
+		compadd -- %s
+	else
+		compadd -- %s
+		_command_names -e
+		_files
+	fi
+}
+compdef _cpu cpu
+`
+
+// Returns the names of cpu's flags with a dash, sorted.
+func flagNames() []string {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	return names
+}
+
+// Returns the names of the subcommands, sorted.
+func subcommandNames() []string {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	for name := range fleetSubcommands {
+		names = append(names, name)
+	}
+	names = append(names, runSubcommands...)
+	sort.Strings(names)
+	return names
+}
+
+// Lists the remotes offered for -r: the hosts of ssh_config(5), the
+// profiles, and the groups of the pools file and of the -inventory.
+func completionRemotes() []string {
+	remotes := knownHosts()
+	if profiles, err := readProfiles(profilesFile()); err == nil {
+		for name := range profiles {
+			remotes = append(remotes, groupPrefix+name)
+		}
+	}
+	if f, err := os.Open(poolsFile()); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if fields := strings.Fields(s.Text()); len(fields) > 1 {
+				remotes = append(remotes, groupPrefix+fields[0])
+			}
+		}
+		f.Close()
+	}
+	if inv := loadInventory(); inv != nil {
+		remotes = append(remotes, inv.all...)
+		for g := range inv.hosts {
+			remotes = append(remotes, groupPrefix+g)
+		}
+		for g := range inv.children {
+			remotes = append(remotes, groupPrefix+g)
+		}
+	}
+	sort.Strings(remotes)
+	return remotes
+}
+
+// Writes completions for fish, which come with the usage of each flag.
+func writeFishCompletion() {
+	fmt.Println("complete -c cpu -o r -x -a '(cpu completion -remotes 2>/dev/null)' -d 'remote compute machine'")
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "r" {
+			return
+		}
+		usage := strings.ReplaceAll(strings.ReplaceAll(f.Usage, "`", ""), "'", `\'`)
+		arg := " -r"
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			arg = ""
+		}
+		fmt.Printf("complete -c cpu -o %s%s -d '%s'\n", f.Name, arg, usage)
+	})
+	fmt.Printf("complete -c cpu -n __fish_use_subcommand -a '%s'\n", strings.Join(subcommandNames(), " "))
+}
+
+// completion writes a script completing cpu's command line for bash,
+// zsh or fish, to be sourced from the shell's rc file.
+func completion(args []string) int {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	remotes := fs.Bool("remotes", false, "list the remotes to complete, for the scripts")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s completion bash | zsh | fish\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *remotes {
+		for _, r := range completionRemotes() {
+			fmt.Println(r)
+		}
+		return 0
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	flags, subs := strings.Join(flagNames(), " "), strings.Join(subcommandNames(), " ")
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Printf(bashCompletion, flags, subs)
+	case "zsh":
+		fmt.Printf(zshCompletion, flags, subs)
+	case "fish":
+		writeFishCompletion()
+	default:
+		fs.Usage()
+		return EX_USAGE
+	}
+	return 0
+}
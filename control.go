@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var persist = flag.Duration("persist", 10*time.Minute,
+	"share connections between invocations, keeping them open this long after the last session; 0 disables sharing")
+
+// Directory holding the control sockets of master connections,
+// or "" when connection sharing is disabled.
+var controlDir string
+
+// Prepares the control socket directory, removing sockets left behind
+// by master connections that are no longer running.
+func setupControlDir() error {
+	if *persist <= 0 {
+		return nil
+	}
+	dir := filepath.Join(os.TempDir(), "cpu-"+strconv.Itoa(os.Getuid()))
+	if rt := os.Getenv("XDG_RUNTIME_DIR"); rt != "" {
+		dir = filepath.Join(rt, "cpu")
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	// another user may have created it first
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if err := checkControlDir(dir, fi); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Type()&os.ModeSocket == 0 {
+			continue
+		}
+		sock := filepath.Join(dir, e.Name())
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			conn.Close()
+		} else if isConnRefused(err) {
+			if verbose >= logCommands {
+				log.Println("removing stale control socket", sock)
+			}
+			os.Remove(sock)
+		}
+	}
+
+	controlDir = dir
+	return nil
+}
+
+// Shares connections to login between cpu invocations where possible,
+// unless ssh_config(5) or the ssh arguments already say how to.
+func setupConnectionSharing(login string) {
+	if conf := effectiveConfig(login); sshArgsSetControl() || conf["controlpath"] != "" || conf["controlmaster"] != "" && conf["controlmaster"] != "false" {
+		explainf("leaving connection sharing to the ControlMaster and ControlPath of ssh's configuration")
+		return
+	}
+	if err := setupControlDir(); err != nil {
+		log.Println("connection sharing disabled:", err)
+		return
+	}
+	ok := startMaster(login)
+	if !ok && remediateHostKey(login) {
+		ok = startMaster(login)
+	}
+	for attempt := 1; !ok && attempt <= *retries; attempt++ {
+		backoff(attempt)
+		ok = startMaster(login)
+	}
+}
+
+// Reports whether the arguments for ssh(1) set up connection sharing
+// themselves, which ssh -G does not show for ControlPath=none.
+func sshArgsSetControl() bool {
+	extra, _ := extraSshArgs()
+	args := strings.ToLower(extra + " " + strings.Join(compatSshArgs, " "))
+	return strings.Contains(args, "controlpath") || strings.Contains(args, "controlmaster")
+}
+
+// Options making ssh(1) share connections through a master.  The
+// socket names are hashes of the connection parameters, which keeps
+// them short and unique however long the host names are.
+func makeControlOptions() []string {
+	if controlDir == "" {
+		return nil
+	}
+	return []string{
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(controlDir, "%C"),
+		"-o", fmt.Sprintf("ControlPersist=%d", int(persist.Seconds())),
+	}
+}
+
+// Ensures a master connection to login is running, starting one in
+// the background if not.  Starting it explicitly, rather than letting
+// the first session become the master, keeps the master from holding
+// on to that session's standard streams.  Reports whether the master
+// is running.
+func startMaster(login string) bool {
+	if controlDir == "" {
+		return true
+	}
+	check := exec.Command("ssh", append(makeSshOptions(), "-O", "check", login)...)
+	if check.Run() == nil {
+		return true
+	}
+
+	args := append(makeSshOptions(), "-f", "-N", login)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin
+	if verbose >= logCommands {
+		log.Println(cmd)
+	}
+	if err := cmd.Run(); err != nil {
+		if verbose >= logCommands {
+			log.Println("starting master connection:", err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// The Windows port of OpenSSH does not support connection sharing.
+func checkControlDir(dir string, fi os.FileInfo) error {
+	return errors.New("not supported on Windows")
+}
+
+func isConnRefused(err error) bool {
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Subcommands of cpu.  They take precedence over remote programs of
+// the same name, which can still be run through the run subcommand:
+//
+//	% cpu run cp a b
+var subcommands = map[string]func(login string, path string, args []string) int{
+	"ab":         ab,
+	"attach":     attach,
+	"checkout":   checkout,
+	"cp":         cp,
+	"flamegraph": flamegraph,
+	"forwards":   forwards,
+	"jobs":       jobs,
+	"journal":    journal,
+	"logs":       logs,
+	"parity":     parity,
+	"perf":       perf,
+	"pprof":      pprof,
+	"share":      share,
+	"status":     status,
+	"sync":       syncTree,
+	"wall":       wall,
+	"who":        who,
+}
+
+// cp copies files between the remote directory and the local system.
+// By default the sources are on the remote, relative to the mapped
+// directory, and the destination is local.  With -push the direction
+// is reversed.
+func cp(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("cp", flag.ExitOnError)
+	toRemote := fs.Bool("push", false, "copy local sources to the remote destination")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s cp [-push] source ... destination\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	if !*toRemote && lowTrust() {
+		exit(EX_USAGE, "copying from a remote of low trust is refused")
+	}
+	srcs, dst := fs.Args()[:fs.NArg()-1], fs.Arg(fs.NArg()-1)
+	path = rcpu.MapPath(path)
+	if *toRemote {
+		dst = remotePath(login, path, dst)
+	} else {
+		for i, src := range srcs {
+			srcs[i] = remotePath(login, path, src)
+		}
+	}
+
+	scpArgs := append(makeSshOptions(), "-r")
+	if kib := bandwidthLimit(); kib > 0 {
+		// scp takes the limit in Kbit/s
+		scpArgs = append(scpArgs, "-l", strconv.FormatInt(kib*8, 10))
+	}
+	scpArgs = append(scpArgs, srcs...)
+	scpArgs = append(scpArgs, dst)
+	cmd := exec.Command("scp", scpArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if verbose >= logCommands {
+		log.Println(cmd)
+	}
+	explainf("executing %s", cmd)
+	bugReport.exec(cmd.Args)
+
+	if err := cmd.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode()
+		}
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	return 0
+}
+
+// Resolves file relative to the remote directory dir, in scp(1) syntax.
+func remotePath(login string, dir string, file string) string {
+	if !strings.HasPrefix(file, "/") && !strings.HasPrefix(file, "~") {
+		file = dir + "/" + file
+	}
+	return rcpu.HostPath(login, file)
+}
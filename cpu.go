@@ -1,58 +1,25 @@
-/*
-cpu runs shell commands on a remote system preserving the local
-environment.
-
-cpu provides a thin layer around ssh(1) that attempts to deduce
-which directory on the remote the command should be run in.  When
-there is an equivalent directory to the current working directory
-on the remote system, the command gets executed under that:
-
-	% cd src/gecko/
-	% cpu -r buildmachine ./mach build
-
-Sometimes it is necessary to give cpu extra instructions for which
-directory to run the command under:
-
-	% cpu -r buildmachine:~/src/gecko/ ./mach build
-
-cpu attaches the local TTY to the remote TTY so that interactive
-programs such as top(1) can also be used:
-
-	% cpu -r buildmachine top
-
-The connection closes when the interactive program terminates.
-
-Used standalone, cpu does not offer many benefits over ssh(1) with
-a few extra arguments.  However when combined with a bit of shell
-magic to automatically set CPU_REMOTE (-r) as you cd into a directory
-where you want commands to be run on a remote CPU machine, it all
-becomes quite powerful:
-
-	...
-	% cd src/gecko/
-	% cpu ./mach build
-*/
 package main // import "sny.no/cpu"
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
-	"os/user"
+	"os/signal"
 	"path"
-	"strconv"
 	"strings"
-)
+	"time"
 
-/*
-#include <unistd.h>
-*/
-import "C"
+	"sny.no/cpu/rcpu"
+)
 
 var (
 	EX_USAGE     = 64
+	EX_DATAERR   = 65
+	EX_NOHOST    = 68
+	EX_SYNC      = 74
 	EX_CMDNFOUND = 127
 )
 
@@ -62,143 +29,654 @@ var (
 	shell = flag.String("s", os.Getenv("SHELL"),
 		"override shell to use on remote")
 	// TODO(ato): add support for passing through environ(7)
-	verbose = flag.Bool("v", false, "increase verbosity")
+	syncDir = flag.Bool("sync", false,
+		"rsync the working directory to the remote before running")
+	x11      = flag.Bool("X", false, "enable X11 forwarding")
+	compress = flag.Bool("C", os.Getenv("CPU_COMPRESS") != "",
+		"compress the connection, for verbose output over slow links")
+	locale = flag.Bool("locale", true,
+		"forward LANG and LC_* so that the remote uses the local locale")
+	expand = flag.String("expand", "remote",
+		"expand variables in the command on the `remote`, locally, or none at all")
+	sendEnv = flag.Bool("sendenv", os.Getenv("CPU_SENDENV") != "",
+		"forward variables with ssh's SendEnv rather than in the command, for remotes whose sshd accepts them")
+	agent   = flag.Bool("A", false, "enable forwarding of the authentication agent")
+	noAgent = flag.Bool("no-A", false,
+		"disable forwarding of the authentication agent, even if ssh_config enables it")
+	jump = flag.String("J", os.Getenv("CPU_JUMP"),
+		"comma-separated jump hosts to connect through, as with ssh -J")
+	watch = flag.Duration("watch", 0,
+		"keep the working directory and the remote in sync in both directions, polling at this interval")
+	pull    stringList
+	cleanup stringList
+
+	// Where the remote was taken from if neither -r nor CPU_REMOTE
+	// gave it, for -explain.
+	remoteSource string
+
+	// Login and port given in the remote specification, the port ""
+	// for the default.
+	remoteLogin string
+	remotePort  string
 )
 
+func init() {
+	flag.Var(&pull, "pull",
+		"glob of files to fetch back from the remote after running (repeatable)")
+	flag.Var(&cleanup, "cleanup",
+		"shell `command` to run on the remote when the command ends, is interrupted, or loses the connection (repeatable)")
+}
+
 func main() {
-	flag.Parse()
-	command := flag.Args()
+	var command []string
+	if gitMode() || transportMode() {
+		command = parseSshArgs(os.Args[1:])
+		setupConnectionSharing(*remote)
+		os.Exit(transport(*remote, command))
+	} else if compatMode() {
+		command = parseSshArgs(os.Args[1:])
+	} else {
+		flag.Parse()
+		command = parseRunSubcommand(flag.Args())
+		setupLogging()
+	}
+	if *version {
+		printVersion()
+		os.Exit(0)
+	}
 
-	if len(*remote) == 0 {
-		exit(EX_USAGE, "missing remote machine")
+	if len(command) > 0 && !compatMode() && !explicitRun {
+		if sub, ok := fleetSubcommands[command[0]]; ok {
+			os.Exit(sub(command[1:]))
+		}
+	}
+	if *local {
+		os.Exit(runLocal(command))
 	}
 	if len(command) == 0 {
 		exit(EX_USAGE, "missing command")
 	}
+	if len(*remote) == 0 {
+		*remote, remoteSource = gitConfigRemote(), "git config cpu.remote"
+	}
+	if len(*remote) == 0 {
+		*remote, remoteSource = pickRemote(), "the remotes offered to pick from"
+	}
+	if len(*remote) == 0 {
+		exit(EX_USAGE, "missing remote machine")
+	}
+	*remote = resolveProfile(*remote)
+	checkTrust()
+	if *envFile != "" {
+		vars, err := readEnvFile(*envFile)
+		if err != nil {
+			exit(EX_DATAERR, "%v", err)
+		}
+		envFileVars = vars
+	}
 
-	login, path := splitLoginPath(*remote)
-	rcpu(login, path, command)
+	if *capture != "" {
+		bugReport = newReport(*capture)
+	}
+	explainRemote()
+	if isGroup(*remote) {
+		*remote = pickFromPool(*remote)
+	} else {
+		*remote = resolveInventoryHost(*remote)
+	}
+	if strings.HasPrefix(*remote, wslPrefix) {
+		distro, path := splitWSL(*remote)
+		code := runWSL(distro, path, command)
+		bugReport.write()
+		os.Exit(code)
+	}
+	if strings.HasPrefix(*remote, k8sPrefix) {
+		p, path := splitPod(*remote)
+		code := runPod(p, path, command)
+		bugReport.write()
+		os.Exit(code)
+	}
+	login, port, path := splitLoginPath(*remote)
+	remoteLogin, remotePort = login, port
+	bugReport.set("login", login)
+	bugReport.set("port", port)
+	bugReport.set("path", path)
+	bugReport.mark("config")
+	setupKeepAlive(login)
+	setupConnectionSharing(login)
+	bugReport.mark("connect")
+	resolveRemoteOS(login)
+	if sub, ok := subcommands[command[0]]; ok && !compatMode() && !explicitRun {
+		code := sub(login, path, command[1:])
+		bugReport.write()
+		os.Exit(code)
+	}
+	if command, next := splitChain(command); next != nil {
+		code := chain(login, path, command, next)
+		bugReport.write()
+		os.Exit(code)
+	}
+	command = rewriteCommand(login, command)
+	// kept as given, for suggesting commands of a similar name
+	argv := command
+	joined, err := expandHostVars(*remote, rcpu.Join(command, expansion(), os.Getenv))
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	command = []string{joined}
+	if *dryRun {
+		os.Exit(printDryRun(login, path, command))
+	}
+	approveRun(*remote, command[0])
+	if *scratch {
+		dir, err := makeScratchDir(login)
+		if err != nil {
+			exit(EX_SYNC, "%v", err)
+		}
+		explainf("using scratch directory %s instead of %s", dir, path)
+		path = dir
+	}
+	if *syncDir || *scratch {
+		cwd, _ := os.Getwd()
+		explainf("pushing %s to %s:%s with rsync", cwd, login, path)
+		if err := push(cwd, login, path); err != nil {
+			if *scratch && !*keep {
+				removeScratchDir(login, path)
+			}
+			exit(EX_SYNC, "push: %v", err)
+		}
+		bugReport.mark("push")
+	}
+	if *at != "" || *whenIdle {
+		if *mount || *overlay != "" || *watch > 0 || len(pull) > 0 {
+			exit(EX_USAGE, "-at and -when-idle cannot be used with -mount, -overlay, -watch, or -pull")
+		}
+		code := deferCommand(login, path, command)
+		if *scratch {
+			fmt.Fprintf(os.Stderr, "%s: kept %s:%s\n", os.Args[0], login, path)
+		}
+		bugReport.write()
+		os.Exit(code)
+	}
+	var exported *export
+	if *mount {
+		cwd, _ := os.Getwd()
+		var err error
+		if exported, err = mountExport(login, cwd); err != nil {
+			exit(EX_SYNC, "mount: %v", err)
+		}
+		explainf("serving %s to %s over SFTP, mounted at %s", cwd, login, exported.dir)
+		path = exported.dir
+	}
+	if *overlay != "" {
+		var err error
+		if overlayDir, err = makeOverlayDir(login); err != nil {
+			exit(EX_SYNC, "%v", err)
+		}
+	}
+	var stopRelay func()
+	if *sudo {
+		var err error
+		if stopRelay, err = relayPassword(login); err != nil {
+			exit(EX_NOHOST, "%v", err)
+		}
+	}
+	var stopWatch func()
+	if *watch > 0 {
+		cwd, _ := os.Getwd()
+		explainf("keeping %s and %s:%s in sync every %v", cwd, login, path, *watch)
+		stopWatch = newWatcher(cwd, login, path).start(*watch)
+	}
+	bugReport.mark("setup")
+	started := time.Now()
+	project, _ := os.Getwd()
+	stopETA := showETA(login, project, strings.Join(command, " "))
+	usual = usualRun(login, project, strings.Join(command, " "))
+	code := runRemote(login, path, command)
+	if code == sshFailure && remediateHostKey(login) {
+		code = runRemote(login, path, command)
+	}
+	// interactive sessions are not run again, as their input is gone
+	for attempt := 1; code == sshFailure && attempt <= *retries && !wantTTY(); attempt++ {
+		backoff(attempt)
+		code = runRemote(login, path, command)
+	}
+	if code == sshFailure {
+		checkHost(login)
+	} else {
+		recordSeen(login)
+	}
+	if stopWatch != nil {
+		stopWatch()
+	}
+	if stopRelay != nil {
+		stopRelay()
+	}
+	if exported != nil {
+		if err := exported.unmount(); err != nil {
+			log.Println("unmount:", err)
+		}
+	}
+	bugReport.mark("command")
+	if overlayDir != "" {
+		if err := finishOverlay(login, overlayDir, rcpu.MapPath(path)); err != nil {
+			log.Println(err)
+		}
+	}
+	took := time.Since(started)
+	stopETA(took)
+	if code != sshFailure {
+		recordRun(run{started, login, project, code, took, strings.Join(command, " "), outputSize.count()})
+	}
+	notify(login, command, code, took)
+	suggest(code, login, argv)
+	if len(pull) > 0 {
+		cwd, _ := os.Getwd()
+		explainf("fetching %s from %s:%s", strings.Join(pull, ", "), login, path)
+		if err := fetch(login, path, cwd, pull); err != nil {
+			exit(EX_SYNC, "pull: %v", err)
+		}
+		bugReport.mark("pull")
+	}
+	if *scratch && !*keep {
+		explainf("removing scratch directory %s", path)
+		if err := removeScratchDir(login, path); err != nil {
+			log.Println(err)
+		}
+	} else if *scratch {
+		fmt.Fprintf(os.Stderr, "%s: kept %s:%s\n", os.Args[0], login, path)
+	}
+	bugReport.mark("cleanup")
+	printTiming()
+	if err := bugReport.write(); err != nil {
+		log.Println("capture:", err)
+	}
+	os.Exit(code)
 }
 
 // TODO(ato): this needs improvement
 func makeEnvironment(environ []string) string {
-	var env = make([]string, 2)
+	return rcpu.Exports(forwardedVars(environ))
+}
+
+// Picks the variables to forward to the remote out of environ.
+func forwardedVars(environ []string) []string {
+	var env []string
 	for _, kv := range environ {
 		if strings.HasPrefix(kv, "TERM=") || strings.HasPrefix(kv, "PAGER=") {
 			env = append(env, kv)
+		} else if *locale && (strings.HasPrefix(kv, "LANG=") || strings.HasPrefix(kv, "LC_")) {
+			env = append(env, kv)
 		}
 	}
-	return strings.Join(env, " ")
+	env = append(env, clientVars()...)
+	return trustedVars(append(env, envFileVars...))
 }
 
 // Attempt to reuse same shell as on the local system.
 func makeShellWrapper(shell string, cmd string) string {
+	if *rcFile {
+		return makeMinimalRCWrapper(cmd)
+	}
 	switch path.Base(shell) {
 	case "bash":
-		return fmt.Sprintf("bash -ci %s", strconv.Quote(cmd))
+		explainf("local shell is bash, so running the command in an interactive bash for its rc files")
 	default:
-		if *verbose {
+		explainf("no wrapper for local shell %q, running the command with sh -c", shell)
+		if verbose >= logCommands {
 			log.Println("unknown shell:", shell)
 		}
-		return strconv.Quote(cmd)
 	}
+	return rcpu.WrapShell(shell, cmd)
+}
+
+// Quotes dir for sh(1), leaving a leading ~ to refer to the home
+// directory.
+func quoteDir(dir string) string {
+	switch {
+	case dir == "~":
+		return dir
+	case strings.HasPrefix(dir, "~/"):
+		return "~/" + rcpu.Quote(dir[2:])
+	}
+	return rcpu.Quote(dir)
 }
 
 // Crafts the full command to be execute on the remote.
 func makeRemoteCmd(cwd string, args []string) string {
+	if windowsRemote() {
+		return makeWindowsCmd(cwd, args)
+	}
 	cmd := strings.Join(args, " ")
-	env := makeEnvironment(os.Environ())
-	wrapper := makeShellWrapper(*shell, cmd)
-	return fmt.Sprintf("{ cd %s && %s %s; }", cwd, env, wrapper)
+	env := ""
+	if *sendEnv {
+		explainf("leaving the variables to ssh's SendEnv, so they are not part of the command")
+	} else {
+		// exported for both the policy and the command
+		env = makeEnvironment(os.Environ())
+	}
+	explainEnvironment(forwardedVars(os.Environ()))
+	wrapper := makeShellWrapper(*shell, makeFramingCmd()+cmd)
+	redirect := ""
+	if stderrPipe != "" {
+		redirect = fmt.Sprintf(" 2>%s", stderrPipe)
+	}
+	return fmt.Sprintf("{ %s%s%s%s%scd %s && %s%s%s%s%s%s%s; }",
+		makeLockDirsCmd(), makeTerminalSizeCmd(), makePresenceCmd(cwd, args), makeCleanupTrap(), makePromptCmd(), cwd, makeUmaskCmd(), env, makePolicyCmd(cmd), policyNice,
+		makeCommandPrefix(), wrapper, redirect)
 }
 
-func makeSshArgs(login string) []string {
-	args := make([]string, 0)
+// Cleans up after the command when the remote shell exits, including
+// when the connection is lost.
+func makeCleanupTrap() string {
+	var cmds []string
+	if *presence {
+		cmds = append(cmds, "rm -f $f")
+	}
+	if *scope || len(scopeProperties) > 0 {
+		cmds = append(cmds, "systemctl --user stop "+scopeUnit+" 2>/dev/null")
+	}
+	if wantPrompt() {
+		cmds = append(cmds, `rm -rf "$pd"`)
+	}
+	for _, c := range cleanup {
+		explainf("running %q on the remote when the command ends", c)
+		cmds = append(cmds, "{ "+c+"; }")
+	}
+	if len(cmds) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("trap %s EXIT; trap 'exit 129' HUP INT TERM; ", rcpu.Quote(strings.Join(cmds, "; ")))
+}
 
-	// suppress ssh(1) output when CPU_SSH_ARGS is not given
-	if os.Getenv("CPU_SSH_ARGS") == "" {
+// Programs the shell wrapper is run under, ending in a space.
+func makeCommandPrefix() string {
+	prefix := makeTimeoutPrefix() + makeScopePrefix() + makePriorityPrefix() + makeSudoPrefix()
+	if *readOnly {
+		explainf("running the command in a read-only view of the directory")
+		prefix += readOnlyPrefix
+	}
+	if overlayDir != "" {
+		explainf("running the command on an overlay with its upper layer in %s", overlayDir)
+		prefix += makeOverlayPrefix(overlayDir)
+	}
+	return prefix
+}
+
+// Options passed to every ssh(1) invocation, including those made
+// on our behalf by rsync(1).
+func makeSshOptions() []string {
+	// suppress ssh(1) output when no extra arguments are given
+	args := makeTrustOptions()
+	if remotePort != "" {
+		args = append(args, "-o", "Port="+remotePort)
+	}
+	if extra, _ := extraSshArgs(); extra == "" {
 		args = append(args, "-o LogLevel=QUIET")
 	} else {
-		sshArgs := strings.Fields(os.Getenv("CPU_SSH_ARGS"))
-		args = append(args, sshArgs...)
+		args = append(args, strings.Fields(extra)...)
+	}
+	if *jump != "" {
+		args = append(args, "-J", *jump)
 	}
+	if verbose >= logTimings {
+		args = append(args, "-v")
+	}
+	if *compress {
+		args = append(args, "-C")
+	}
+	args = append(args, hostKeyOptions...)
+	args = append(args, keepAliveOptions...)
+	args = append(args, makeControlOptions()...)
+	return append(args, compatSshArgs...)
+}
 
-	// force pseudo-terminal allocation if any FDs are TTYs
-	if isatty(os.Stdout) || isatty(os.Stdin) || isatty(os.Stderr) {
+func makeSshArgs(login string) []string {
+	args := makeSshOptions()
+	if extra, from := extraSshArgs(); extra == "" {
+		explainf("neither CPU_SSH_ARGS nor a profile gives ssh arguments, so silencing ssh")
+	} else {
+		explainf("passing %s to ssh: %s", from, extra)
+	}
+
+	if wantTTY() {
+		explainf("a standard stream is a terminal, so forcing a remote pseudo-terminal")
 		args = append(args, "-tt")
 	} else {
+		explainf("no standard stream is a terminal, so disabling the pseudo-terminal and escape character")
 		args = append(args, "-e", "none", "-T")
 	}
+	if *x11 {
+		args = append(args, "-X")
+	} else if lowTrust() {
+		args = append(args, "-x")
+	}
+	if *noAgent {
+		args = append(args, "-a")
+	} else if *agent {
+		args = append(args, "-A")
+	}
+	args = append(args, makeForwardArgs()...)
+	args = append(args, makeSendEnvArgs()...)
 
 	return append(args, login)
 }
 
-func rcpu(login string, path string, args []string) {
-	path = relativizeHomeDir(path)
+// Has ssh(1) send the forwarded variables in its environment request
+// with -sendenv, instead of assigning them in the remote command.
+func makeSendEnvArgs() []string {
+	if !*sendEnv || windowsRemote() {
+		return nil
+	}
+	var args []string
+	for _, kv := range forwardedVars(os.Environ()) {
+		args = append(args, "-o", "SendEnv="+kv[:strings.Index(kv, "=")])
+	}
+	return args
+}
 
-	fullArgs := append(makeSshArgs(login), makeRemoteCmd(path, args))
+// Reports whether the remote command gets a pseudo-terminal: if any of
+// the standard streams is a terminal, except when feeding a file, which
+// a terminal would mangle.
+func wantTTY() bool {
+	return compatTTY == "-t" || compatTTY == "" && *stdinJSON == "" &&
+		(isatty(os.Stdout) || isatty(os.Stdin) || isatty(os.Stderr))
+}
 
-	cmd := exec.Command("ssh", fullArgs...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// Runs cmd on login without a terminal and returns its standard output.
+func remoteOutput(login string, cmd string) ([]byte, error) {
+	args := append(makeSshOptions(), "-T", login, cmd)
+	return exec.Command("ssh", args...).Output()
+}
+
+// Runs args on login under path and returns its exit status.
+func runRemote(login string, path string, args []string) int {
+	if rel := rcpu.MapPath(path); rel != path {
+		explainf("%s is under the home directory, so running in %s on %s", path, rel, login)
+		path = rel
+	} else {
+		explainf("running in %s on %s", path, login)
+	}
 
-	if *verbose {
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	stdin := io.Reader(os.Stdin)
+	if *stdinJSON != "" {
+		f, err := openStdinJSON()
+		if err != nil {
+			exit(EX_DATAERR, "%v", err)
+		}
+		defer f.Close()
+		stdin = f
+	}
+	stdin, restoreTerminal := typeSnippets(stdin)
+	defer restoreTerminal()
+	stdout, stderr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	var f *portForwarder
+	if *autoforward {
+		f = newPortForwarder(login)
+		defer f.close()
+	}
+	if *rewriteURLs {
+		stdout, stderr = &urlRewriter{w: stdout, f: f}, &urlRewriter{w: stderr, f: f}
+	}
+	if f != nil {
+		// outermost, so that ports are found in the output as the remote wrote it
+		stdout, stderr = f.watch(stdout), f.watch(stderr)
+	}
+	stdout, stderr, closeOutput := teeOutput(stdout, stderr)
+	if len(notifySinks) > 0 {
+		outputTail = &tailBuffer{}
+		stdout, stderr = io.MultiWriter(stdout, outputTail), io.MultiWriter(stderr, outputTail)
+	}
+	defer closeOutput()
+	if *record != "" {
+		rec, err := newRecorder(*record)
+		if err != nil {
+			exit(EX_DATAERR, "record: %v", err)
+		}
+		defer rec.close()
+		stdout, stderr = rec.watch(stdout), rec.watch(stderr)
+	}
+	if *logFile != "" {
+		l, err := openSessionLog(*logFile)
+		if err != nil {
+			exit(EX_DATAERR, "log: %v", err)
+		}
+		defer l.close()
+		stdout, stderr = l.watch(stdout, "out"), l.watch(stderr, "err")
+		if *logInput {
+			stdin = l.input(stdin)
+		}
+	}
+	stdout, stderr = bugReport.tee(stdout, stderr)
+	outputSize.reset()
+	stdout, stderr = io.MultiWriter(stdout, &outputSize), io.MultiWriter(stderr, &outputSize)
+	stdout, stderr, flushFrames := frameOutput(stdout, stderr)
+	defer flushFrames()
+	if needStderrPipe() {
+		wait, err := pipeStderr(login, stderr)
+		if err != nil {
+			exit(EX_NOHOST, "%v", err)
+		}
+		defer wait()
+	}
+
+	cmd := exec.Command("ssh", append(makeSshArgs(login), makeRemoteCmd(path, args))...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if *sendEnv {
+		cmd.Env = append(append(os.Environ(), clientVars()...), envFileVars...)
+	}
+	if typingSnippets {
+		// the terminal is only read again when a key is pressed
+		cmd.WaitDelay = time.Second
+	}
+
+	if verbose >= logCommands {
 		log.Println(cmd)
 	}
+	explainf("executing %s", cmd)
+	bugReport.exec(cmd.Args)
+
+	// leave interrupts to ssh, so cpu can clean up after it exits
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	defer signal.Reset(os.Interrupt)
 
 	if err := cmd.Start(); err != nil {
-		exit(EX_CMDNFOUND, err.Error())
+		exit(EX_CMDNFOUND, "%v", err)
 	}
+	timedOut := watchTimeout(cmd)
+	killed := watchAnomalies(login, args, cmd)
 
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	if t, k := timedOut(), killed(); t || k {
+		return EX_TIMEOUT
+	}
+	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exiterr.ExitCode())
+			return exiterr.ExitCode()
 		} else {
 			log.Fatalf("cmd.Wait: %v", err)
 		}
 	}
+	return 0
 }
 
-// If path begins with current user's home directory,
-// replace it with ~ so home directory can be referenced across systems.
-func relativizeHomeDir(path string) string {
-	usr, err := user.Current()
-	if err != nil {
-		log.Println("user.Current():", err)
-		return path
+// Runs cmd, a local program standing in for ssh(1), attached to the
+// standard streams and returns its exit status.
+func runCommand(cmd *exec.Cmd) int {
+	if *dryRun {
+		fmt.Println(quoteArgs(cmd.Args))
+		return 0
 	}
-	if strings.Index(path, usr.HomeDir) == 0 {
-		relPath := path[len(usr.HomeDir):]
-		return fmt.Sprintf("~%s", relPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout, cmd.Stderr = bugReport.tee(os.Stdout, os.Stderr)
+	if verbose >= logCommands {
+		log.Println(cmd)
 	}
-	return path
-}
+	explainf("executing %s", cmd)
+	bugReport.exec(cmd.Args)
 
-// [<user>@]<host>[:<path>] -> login, path
-func splitLoginPath(remote string) (string, string) {
-	var login, path string
-	ss := strings.SplitN(remote, ":", 2)
-	switch len(ss) {
-	case 1:
-		login = ss[0]
-		path, _ = os.Getwd()
-	case 2:
-		login = ss[0]
-		path = ss[1]
+	if err := cmd.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode()
+		}
+		exit(EX_CMDNFOUND, "%v", err)
 	}
-	return login, path
+	return 0
 }
 
-func isatty(fd *os.File) bool {
-	return int(C.isatty(C.int(fd.Fd()))) != 0
+// [<user>@]<host>[#<port>][:<path>] -> login, port, path
+// ssh://[<user>@]<host>[:<port>][/<path>] -> login, port, path
+//
+// Without a path, the working directory is mapped to the remote.
+func splitLoginPath(remote string) (string, string, string) {
+	r, err := rcpu.ParseRemote(remote)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	if r.Port != "" {
+		explainf("remote %q connects to port %s", remote, r.Port)
+	}
+	path := r.Path
+	cwd, _ := os.Getwd()
+	if path != "" {
+		if path, err = expandPath(path); err != nil {
+			exit(EX_USAGE, "%v", err)
+		}
+		explainf("remote %q overrides the working directory with %s", remote, path)
+	} else if wt := checkoutTarget(r.Login, cwd); wt != "" {
+		path = wt
+		explainf("remote %q has no path, so using the worktree %s made by cpu checkout", remote, path)
+	} else {
+		path = cwd
+		explainf("remote %q has no path, so mapping the working directory %s", remote, path)
+	}
+	return r.Login, r.Port, path
 }
 
 func exit(code int, format string, a ...interface{}) {
 	msg := fmt.Sprintf("%s: %s\n", os.Args[0], fmt.Sprintf(format, a...))
 	fmt.Fprintln(os.Stderr, msg)
+	bugReport.write()
 	if code == EX_USAGE {
 		flag.Usage()
 	}
 	os.Exit(code)
 }
+
+// Returns where variables in the command are expanded, from -expand.
+func expansion() rcpu.Expansion {
+	switch *expand {
+	case "remote":
+		return rcpu.ExpandRemote
+	case "local":
+		return rcpu.ExpandLocal
+	case "none":
+		return rcpu.ExpandNone
+	}
+	exit(EX_USAGE, "-expand must be remote, local, or none")
+	return rcpu.ExpandRemote
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestMakeShellWrapperUnknownShell(t *testing.T) {
+	for _, shell := range []string{"", "/bin/fish", `C:\Windows\System32\cmd.exe`} {
+		wrapper := makeShellWrapper(shell, `printf '%s|' one "two words" $((1 + 2))`)
+		out, err := exec.Command("sh", "-c", wrapper).Output()
+		if err != nil {
+			t.Fatalf("makeShellWrapper(%q): %s: %v", shell, wrapper, err)
+		}
+		if got, want := string(out), "one|two words|3|"; got != want {
+			t.Errorf("makeShellWrapper(%q): %s printed %q, want %q", shell, wrapper, got, want)
+		}
+	}
+}
+
+func TestQuoteDir(t *testing.T) {
+	for _, tt := range []struct{ dir, want string }{
+		{"~", "~"},
+		{"~/src/gecko", "~/'src/gecko'"},
+		{"/mnt/c/My Documents", "'/mnt/c/My Documents'"},
+		{"/srv/it's", `'/srv/it'\''s'`},
+		{"~ato/src", "'~ato/src'"},
+	} {
+		if got := quoteDir(tt.dir); got != tt.want {
+			t.Errorf("quoteDir(%q) = %s, want %s", tt.dir, got, tt.want)
+		}
+	}
+}
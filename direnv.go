@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Name of the file marking the top of a project.  Its first line that
+// is neither empty, a comment, nor an assignment is the remote for
+// commands run in the project, and lines of the form CPU_NAME=value
+// set other variables of cpu for it.
+const projectFile = ".cpu"
+
+var projectVar = regexp.MustCompile(`^(CPU_[A-Z0-9_]+)=(.*)$`)
+
+// Variables a project file may not set, as they would let a cloned
+// repository run local commands, through ssh's ProxyCommand or
+// LocalCommand, a notification or an inventory's approval command, or
+// weaken the checks on the remote.
+var unsafeProjectVars = []string{"CPU_SSH_ARGS", "CPU_TRUST", "CPU_HOSTKEY_POLICY", "CPU_NOTIFY", "CPU_INVENTORY"}
+
+// Returns the nearest project file at or above dir, or "".
+func findProjectFile(dir string) string {
+	for {
+		name := filepath.Join(dir, projectFile)
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Returns the remote and the variables set in the project file name.
+func readProjectFile(name string) (remote string, vars [][2]string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := projectVar.FindStringSubmatch(line); m != nil {
+			vars = append(vars, [2]string{m[1], m[2]})
+		} else if remote == "" {
+			remote = line
+		}
+	}
+	return remote, vars, s.Err()
+}
+
+// direnv writes the variables of the project the working directory is
+// in for direnv(1), either to be copied into its .envrc or to be
+// evaluated there, in which case direnv also watches the project file
+// for changes.
+func direnv(args []string) int {
+	fs := flag.NewFlagSet("direnv", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s direnv\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	cwd, _ := os.Getwd()
+	name := findProjectFile(cwd)
+	if name == "" {
+		exit(EX_DATAERR, "no %s file in %s or above", projectFile, cwd)
+	}
+	remote, vars, err := readProjectFile(name)
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	fmt.Printf("watch_file %s\n", rcpu.Quote(name))
+	if remote != "" {
+		fmt.Printf("export CPU_REMOTE=%s\n", rcpu.Quote(remote))
+	}
+	for _, kv := range vars {
+		if contains(unsafeProjectVars, kv[0]) {
+			fmt.Fprintf(os.Stderr, "%s: ignoring %s in %s, which must be set outside the project\n", os.Args[0], kv[0], name)
+			continue
+		}
+		fmt.Printf("export %s=%s\n", kv[0], rcpu.Quote(kv[1]))
+	}
+	return 0
+}
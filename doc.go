@@ -0,0 +1,752 @@
+/*
+cpu runs shell commands on a remote system preserving the local
+environment.
+
+cpu provides a thin layer around ssh(1) that attempts to deduce
+which directory on the remote the command should be run in.  When
+there is an equivalent directory to the current working directory
+on the remote system, the command gets executed under that:
+
+	% cd src/gecko/
+	% cpu -r buildmachine ./mach build
+
+Sometimes it is necessary to give cpu extra instructions for which
+directory to run the command under:
+
+	% cpu -r buildmachine:~/src/gecko/ ./mach build
+
+The path may contain placeholders filled in from the local git
+checkout and environment, so that one CPU_REMOTE serves many
+repositories: {repo} is the name of the checkout, {branch} its
+current branch, {user} the local user name, and {cwd_rel} the working
+directory relative to the top of the checkout:
+
+	% export CPU_REMOTE='buildmachine:~/src/{repo}/{branch}/{cwd_rel}'
+
+cpu attaches the local TTY to the remote TTY so that interactive
+programs such as top(1) can also be used:
+
+	% cpu -r buildmachine top
+
+The connection closes when the interactive program terminates.
+
+Long lines typed over and over while debugging can be bound to the
+function keys F1 to F12 in a .cpusnippets file in the working
+directory, or the file named by -snippets.  Pressing the key in an
+interactive session types the text into the remote instead.  Text in
+double quotes is read as a Go string literal, so that \r presses
+Enter:
+
+	F2 ./mach test dom/base/test --headless
+	F3 "make -C obj check\r"
+
+So that a remote shell is not mistaken for a local one, -prompt or
+CPU_PROMPT gives a marker to put in front of the prompt of interactive
+bash and zsh shells on the remote, in which {host} stands for the
+remote's host name.  The marker is in the colour of the host after a
+command succeeded and red after one failed.  bash is given it in
+PROMPT_COMMAND, so rc files that set PROMPT_COMMAND themselves leave
+it out:
+
+	% cpu -prompt '[{host} via cpu] ' $SHELL
+
+TERM and PAGER are forwarded to the remote, as are the LANG and LC_*
+variables of the locale so that programs format and sort text the
+same way as locally.  Use -locale=false on remotes that do not have
+the local locale installed.
+
+The variables are assigned in the remote command, where they show in
+its process title and the remote shell's history.  With -sendenv or
+CPU_SENDENV they are instead sent along by ssh's SendEnv, which
+requires the remote's sshd_config(5) to list them in AcceptEnv:
+
+	AcceptEnv LANG LC_* PAGER CPU_*
+
+With -client-env or CPU_CLIENT_ENV, the remote command can tell where
+it was run from by CPU_CLIENT_OS, the local operating system as named
+by Go, CPU_CLIENT_CWD, the local working directory, CPU_LOCAL_USER,
+the local user name, and CPU_SESSION_ID, which is unique to each
+invocation of cpu:
+
+	% cpu -client-env 'tar czf "build-$CPU_SESSION_ID.tar.gz" obj'
+
+Commands are run in an interactive bash for its rc files when bash is
+the local shell.  Where those are slow or print messages that would
+end up in the command's output, -rcfile or CPU_RCFILE runs the command
+in a bash that only reads a minimal rc file instead.  It puts ~/bin,
+~/.local/bin, ~/.cargo/bin and ~/go/bin on the PATH, and then reads
+~/.config/cpu/rc on the remote, if there is one, for anything else
+the command needs, such as aliases:
+
+	% cpu -rcfile make
+
+Where the remote's login or rc files print messages that corrupt
+output going to a file or another program, -frame has the remote print
+a marker line unique to the session right before the command, and cpu
+drops it and anything before it:
+
+	% cpu -frame git diff > fix.diff
+
+Settings and secrets for a project need not be exported in the local
+shell first: -env-file forwards the KEY=VALUE lines of a file as used
+by docker(1), and with -sendenv they stay out of the remote's process
+list:
+
+	% cpu -env-file .env.remote ./deploy.sh
+
+The command is shell text for the remote, so variables and globs the
+local shell leaves alone, as in quotes, are expanded on the remote.
+With -expand local, $NAME and ${NAME} outside single quotes are
+replaced by the local values instead, and $$ stands for a $ left to
+the remote.  With -expand none every argument is passed as it is,
+without any expansion:
+
+	% cpu 'echo $HOME'
+	/home/ato
+	% cpu -expand local 'echo $HOME and $$HOME'
+	/Users/ato and /home/ato
+	% cpu -expand none echo '$HOME'
+	$HOME
+
+Used standalone, cpu does not offer many benefits over ssh(1) with
+a few extra arguments.  However when combined with a bit of shell
+magic to automatically set CPU_REMOTE (-r) as you cd into a directory
+where you want commands to be run on a remote CPU machine, it all
+becomes quite powerful.  The hook subcommand writes that magic for
+bash, zsh or fish: it sets CPU_REMOTE to the first line of the nearest
+.cpu file at or above the working directory, and puts back the
+previous value on leaving the project:
+
+	% echo 'eval "$(cpu hook bash)"' >> ~/.bashrc
+	% echo buildmachine > ~/src/gecko/.cpu
+	% cd src/gecko/
+	% cpu ./mach build
+
+A remote can also be kept with the clone of a repository in git's
+configuration, where it is used when neither -r nor CPU_REMOTE is
+given:
+
+	% git config cpu.remote 'buildmachine:~/src/gecko'
+
+Without a remote from any of these, cpu run from a terminal offers the
+hosts of ~/.ssh/config and the groups of remotes to pick from, along
+with how long each takes to answer, using fzf(1) if it is installed.
+
+However the remote is chosen, -local runs the command in the
+working directory of this machine instead, to compare what it does in
+both places by adding or leaving out one flag:
+
+	% cpu -local make test
+
+The ab subcommand runs a command both ways at once and compares the
+exit statuses, how long each took, and whether their output is the
+same, exiting 1 when they differ.  With -output it also shows how the
+output differs:
+
+	% cpu ab -output go env
+
+Before trusting a remote with a build, the parity subcommand compares
+the environment commands get there with the local one: the versions
+of common compilers and tools, build variables such as CC and CFLAGS,
+the locale, limits, and the state of the git checkout.  Those that
+differ are shown as in a diff.  More facts can be listed in
+~/.config/cpu/parity, one name and shell command printing it per line:
+
+	% echo 'protoc protoc --version' >> ~/.config/cpu/parity
+	% cpu parity
+
+Projects using direnv(1) can have it set CPU_REMOTE instead of the
+hook.  The direnv subcommand writes the remote of the .cpu file as an
+export of CPU_REMOTE, along with lines of the file that set other
+variables of the form CPU_NAME=value, for the project's .envrc.  As
+direnv picks up changes to the file without asking again, variables
+that could make cpu run local commands or weaken its checks, such as
+CPU_SSH_ARGS, are ignored there:
+
+	% printf 'buildmachine\nCPU_JUMP=bastion\n' > .cpu
+	% echo 'eval "$(cpu direnv)"' >> .envrc
+
+When the remote has no checkout of its own, -sync rsyncs the current
+directory to the mapped remote directory before the command is run.
+.git and files matching .gitignore are not transferred.  Build outputs can be
+fetched back afterwards by naming them with -pull, which may be given
+several times:
+
+	% cpu -r buildmachine -sync -pull 'dist/**' -pull 'target/*.deb' make
+
+For long interactive sessions, -watch keeps both sides in sync while
+the command runs by polling at the given interval.  It starts by
+pushing whatever differs from the working directory, as -sync does,
+and fetching files that are only on the remote, making the remote
+directory if there is none.  After that, files changed on only one
+side are copied to the other;
+files changed on both are reported as conflicts and left alone.
+Deletions are not propagated.  As with -sync, .git and files matching
+.gitignore are skipped, and so are patterns listed in a .cpuignore
+file:
+
+	% cpu -r buildmachine -watch 2s $SHELL
+
+To leave room on the uplink for other things, -bwlimit or CPU_BWLIMIT
+caps the rate of all file transfers, as with -sync, -pull, -watch and
+the cp subcommand:
+
+	% cpu -bwlimit 5M -sync make
+
+To find out why a command behaves differently on the remote, -explain
+describes each step taken: where the remote came from, how the path
+was mapped, which environment variables were forwarded, which shell
+wrapper was chosen, and the final ssh(1) invocation.
+
+With -n nothing is run; cpu prints the ssh(1) command line, quoted so
+that it can be pasted into a shell, followed by the command the remote
+shell would be given:
+
+	% cpu -n -r buildmachine 'ls *.c'
+
+To find out whether a slow run is down to the network, the transfer
+of files, or the command itself, -timing reports how long each phase
+took: resolving the configuration, connecting and authenticating,
+pushing files with -sync, setting up the remote side, the command,
+fetching files with -pull, and cleaning up:
+
+	% cpu -timing -sync make
+
+For debugging in CI, -v logs the programs cpu runs, -vv also logs the
+steps -explain would describe, and -vvv adds the time taken by each
+stage and ssh(1)'s debug output.  With -log-format json, or
+CPU_LOG_FORMAT=json, each log entry is written as a JSON object on a
+line of its own:
+
+	% cpu -vvv -log-format json make 2>cpu.log
+
+Build steps that should not touch the shared checkout on the remote
+can be run with -scratch, which copies the working directory to a
+fresh directory under ~/.cache/cpu/scratch on the remote, runs the
+command there, fetches any -pull globs, and removes the directory
+again unless -keep is given:
+
+	% cpu -r buildmachine -scratch -pull 'dist/**' ./untrusted-build.sh
+
+When reporting a bug, -capture writes the resolved configuration, the
+constructed command lines, the output, timings, and the relevant
+environment into a tar file that can be attached to the report.
+The user name, home directory, and the values of variables and flags
+that look like secrets, such as -notify's webhook URLs, are redacted
+wherever they appear.
+
+-version prints which build of cpu is in use, from the module version
+and git revision it was built from, along with the Go toolchain and
+the ssh it runs, and the same goes into the -capture:
+
+	% cpu -version
+
+Files can be copied from the remote directory with the cp subcommand,
+which takes paths relative to the mapped directory just like the
+commands run there.  With -push, local files are copied to the remote
+instead:
+
+	% cpu cp obj/dist/app .
+	% cpu cp -push patches/fix.diff obj/
+
+Each host is shown in a colour of its own wherever cpu names it, in
+the -prompt marker, and in the pane titles of the panes subcommand, so
+that it is clear at a glance which machine is which.  The colour is
+picked from the host name, and can be set in ~/.config/cpu/colors with
+a line giving the host and a colour name such as bright-cyan or a
+number of the 256-colour palette:
+
+	% echo 'builder1 blue' >> ~/.config/cpu/colors
+
+The completion subcommand writes a script for bash, zsh or fish that
+completes cpu's flags and subcommands, and the hosts of ~/.ssh/config
+and groups of remotes after -r:
+
+	% echo 'source <(cpu completion bash)' >> ~/.bashrc
+
+When something does not work, the doctor subcommand checks each link
+cpu relies on: ssh and its agent here, and for the remote, or else
+every host of ~/.ssh/config, that it answers and can be logged in to
+without a prompt, its shell, the mapped directory, and its clock.
+Each problem it finds comes with a fix:
+
+	% cpu doctor buildmachine
+
+The ping subcommand is quicker, and tells where time goes when cpu is
+slow.  For the same remotes, it times connecting to each, logging in
+over a new connection, and a round trip over the session once it is
+up:
+
+	% cpu ping @builders
+
+Commands can also be given after run, and an interactive login shell
+in the mapped directory is started with shell.  Both take the same
+flags as cpu itself, after their name:
+
+	% cpu run -sync make
+	% cpu shell
+
+The sync subcommand pushes the working directory as -sync does,
+without running anything.
+
+Subcommands take precedence over remote programs of the same name;
+use run to run those, as in "cpu run cp a b".
+
+With -mount, nothing is copied at all.  The working directory is
+instead served from the local system over SFTP and mounted on the
+remote with sshfs(1) for the duration of the command, so the remote
+sees exactly the local tree:
+
+	% cpu -r buildmachine -mount make
+
+File metadata of a -mount is cached on the remote for five seconds,
+which keeps the stat storms of compilers from crossing the network for
+every header.  Use -mount-cache to change how long, or 0 when the
+remote must see local changes immediately.
+
+Scripts written for ssh(1) can be pointed at cpu unchanged by running
+it with -ssh-compat as the first argument, or by invoking it through
+a symbolic link named cpus.  It then accepts ssh's own options and
+"[options] destination [command]" arguments, and the command gains
+cpu's directory mapping and environment forwarding:
+
+	% ln -s cpu ~/bin/cpus
+	% cpus -p 2222 -l ato buildmachine make
+
+cpu can also be used as git's ssh transport, in which case it
+recognises git's remote commands and passes them straight to ssh(1)
+without any directory mapping.  git has to be told that cpu accepts
+ssh's options:
+
+	% git config core.sshCommand cpu
+	% git config ssh.variant ssh
+
+Ports can be forwarded for the duration of the command with -L and -R,
+which take the same arguments as in ssh(1).  To reach development
+servers started on the remote, -autoforward watches the command's
+output for messages such as "listening on :3000" and forwards each
+announced port to the same port on the local system:
+
+	% cpu -autoforward npm run dev
+
+With -rewrite-urls, URLs such as http://0.0.0.0:3000 in the output
+are rewritten to the local address of the forward of their port, so
+that the URL a development server prints can be opened as it is.
+
+Local ports below 1024 can only be listened on by root on most
+systems.  When a forward asks for one that cannot be listened on, the
+port 8000 higher is forwarded in its place, making 80 8080 and 443
+8443, or any free port if that is taken, and cpu says which.
+
+The tunnels a project usually needs can be kept up independently of
+any command.  Each line of a .cpuforwards file in the working
+directory names a set of forwards, given as ports forwarded to the
+same port on the remote or as with -L.  The forwards subcommand
+brings the named sets, or all of them, up or down in the background,
+and shows which are up:
+
+	% cat .cpuforwards
+	web 3000 9229
+	db 5432:db.internal:5432
+	% cpu forwards up web
+	% cpu forwards status
+	% cpu forwards down
+
+Similarly, -transport-only as the first argument makes cpu accept
+ssh's arguments and provide just the connection, so that it can serve
+as rsync's remote shell for ad-hoc transfers:
+
+	% rsync -a -e 'cpu -transport-only' obj/ buildmachine:obj/
+
+Remote GUI programs can display locally when X11 forwarding is enabled
+with -X.  To enable it for a machine permanently, set ForwardX11 in the
+machine's Host section of ssh_config(5).
+
+By default, connections to a remote are shared between invocations
+through an ssh master connection, which is kept open for ten minutes
+after the last session ends.  This makes repeated commands, as well as
+git and rsync transfers through cpu, start without a new handshake.
+The control sockets are kept in $XDG_RUNTIME_DIR/cpu, or else in
+cpu-<uid> in the temporary directory.  The lifetime is set with
+-persist, and -persist 0 disables sharing.  Remotes for which
+ssh_config(5) or CPU_SSH_ARGS set ControlMaster or ControlPath are
+left to share connections as those say.
+
+Idle connections are probed every 30 seconds so that NAT gateways do
+not drop them during long quiet compiler phases, unless the remote's
+Host section of ssh_config(5) sets ServerAliveInterval itself.  The
+interval is set with -keepalive or CPU_KEEPALIVE, and the number of
+unanswered probes after which the connection is given up with
+-keepalive-count.
+
+Over slow links, -C or setting CPU_COMPRESS compresses the connection,
+which helps with verbose build output.  Compression can also be
+enabled for particular remotes with Compression in ssh_config(5).
+
+Likewise -A forwards the authentication agent, for build steps that
+fetch private repositories, and -no-A refuses to forward it even where
+ForwardAgent is enabled in ssh_config(5).
+
+When the host key of a known remote has changed, cpu shows the known
+and the presented key fingerprints and when the remote was last used,
+and asks whether to abort, to accept the new key and update
+known_hosts, or to connect once in ssh's restricted mode without
+forwarding or password authentication.  CPU_HOSTKEY_POLICY can be set
+to abort, update, or restricted to decide without asking.
+
+Remotes behind a bastion are reached by naming one or more jump hosts
+with -J or CPU_JUMP, which apply to every connection cpu makes,
+including those for -sync and cp.  Remotes that are always behind the
+same bastion are better served by ProxyJump in ssh_config(5):
+
+	% cpu -J bastion.example.com -r lab-builder make
+
+When ssh cannot connect to a host whose name, as ssh_config(5)
+resolves it, is not known to DNS, cpu says so and suggests the hosts
+of ~/.ssh/config with a similar name.
+
+A non-default port can be given in the remote, either in URL form or
+after a # sign:
+
+	% cpu -r ssh://ato@buildmachine:2222/~/src/gecko ./mach build
+	% cpu -r ato@buildmachine#2222:~/src/gecko ./mach build
+
+Exploratory commands can be kept from modifying a shared checkout with
+-read-only, which runs them with the remote directory mounted
+read-only.  This uses an unprivileged user namespace, in which the
+command appears to run as root.
+
+IPv6 addresses are written in brackets:
+
+	% cpu -r 'ato@[2001:db8::1]#2222:~/src/gecko' ./mach build
+
+Destructive build steps can be tried out with -overlay, which runs the
+command on a copy-on-write overlay of the remote directory, with the
+changes going to a per-user location under ~/.cache/cpu/overlay.
+Afterwards the changes are discarded, kept there for inspection, or
+applied to the directory, depending on whether -overlay was given
+discard, keep, or apply:
+
+	% cpu -overlay discard make distclean all
+
+With -scope the command runs in a transient scope of the remote user's
+systemd instance, which is stopped along with every process left in it
+when the command ends or the connection drops.  Resource limits and
+scheduling for the whole build are set with -scope-property, using the
+properties of systemd.resource-control(5) and systemd.exec(5):
+
+	% cpu -scope-property MemoryMax=16G -scope-property Nice=10 make -j
+
+Without systemd, heavy builds can be kept from crowding out others on
+a shared remote with -nice, which lowers the command's priority,
+-ionice, which puts it in the idle, best-effort, or realtime I/O
+scheduling class of ionice(1), optionally followed by :level, and
+-umask, which sets the permissions of the files it creates:
+
+	% cpu -nice 10 -ionice idle -umask 002 make -j
+
+With -sudo the command is run with sudo on the remote.  The password is
+asked for on the local terminal and handed to sudo through a
+single-use named pipe read by its askpass helper, rather than typed
+into a prompt on the remote pseudo-terminal:
+
+	% cpu -sudo make install
+
+Servers and containers started for a debugging session need not be
+left running on the remote when it ends.  Each -cleanup command is run
+there when the command exits, is interrupted with Ctrl-C, or loses
+its connection, in the directory the command ran in:
+
+	% cpu -cleanup 'docker rm -f devdb' -cleanup 'pkill -f devserver' ./run-dev.sh
+
+So that hung builds do not block CI jobs forever, -timeout terminates
+the command and every process it started once the given time is up,
+and cpu exits with status 124, as timeout(1) does:
+
+	% cpu -timeout 30m ./mach build
+
+To ride out brief network outages, -retry or CPU_RETRY gives the number
+of times to try again when the connection fails, waiting twice as long
+before each attempt as before the last.  Commands without a terminal
+are run again from the start if the connection drops while they run:
+
+	% cpu -retry 5 ./mach build
+
+Local data can be mixed into a remote command.  Placeholders of the
+form {local:...} are replaced by the output of running their contents
+locally before the command is sent, and -stdin-json feeds a local JSON
+file to the command's standard input:
+
+	% cpu -stdin-json data.json 'jq .x'
+	% cpu git checkout {local:git rev-parse HEAD}
+
+cpu also runs on Windows, using the Windows port of OpenSSH.  Paths
+under the user's profile directory map to the home directory on the
+remote as usual, and other paths lose their drive letter.  Connection
+sharing is not available there.
+
+Remotes running the Windows port of OpenSSH need their commands in
+the syntax of cmd.exe, or of PowerShell if that is the default shell
+there.  Set -remote-os or CPU_REMOTE_OS to windows or powershell, or to
+auto to ask the remote.  The home directory maps to %USERPROFILE%:
+
+	% cpu -remote-os windows -r winbuilder msbuild
+
+On Windows, a local WSL distribution can stand in for a remote
+machine.  A remote of the form wsl:<distribution> runs the command
+through wsl.exe, in the directory where WSL mounts the working
+directory, without any sshd involved:
+
+	% cpu -r wsl:Ubuntu make
+
+Builders running in a Kubernetes cluster are reached with remotes of
+the form k8s:<namespace>/<pod>[/<container>], which execute the command
+in the pod with kubectl(1), mapping the working directory as usual:
+
+	% cpu -r k8s:ci/builder-0 make
+
+Interchangeable builders can be listed as groups in
+~/.config/cpu/pools, one group per line starting with its name.  A
+remote of the form @<name>[:<path>], or pool:<name>[:<path>], runs
+the command on one machine of the group, saying which it picked.  By
+default every machine is asked for its load average and the one with
+the lowest load per processor is picked; -pick or CPU_PICK set to
+first picks the first one to answer in the order listed, and random
+any of them without asking:
+
+	% cat ~/.config/cpu/pools
+	builders builder1 builder2 ato@builder3#2222
+	% cpu -r @builders make -j
+	% cpu -r @builders:~/src/gecko -pick random ./mach build
+
+Fleets already described by an Ansible inventory in INI format need
+not be listed again: with -inventory or CPU_INVENTORY naming the
+inventory file, its groups can be used as @<name> remotes, and its
+hosts as remotes of their own, connecting with their ansible_host,
+ansible_user and ansible_port:
+
+	% cpu -inventory hosts.ini -r @builders make -j
+	% cpu -inventory hosts.ini -r web1:/srv/app ./migrate.sh
+
+The command may refer to the variables of the host it runs on as
+{host.<name>}, so that the host picked from a group runs its own
+variant of it:
+
+	% cpu -inventory hosts.ini -r @web ./deploy --region {host.region}
+
+Hosts for which the inventory sets cpu_approve only run commands
+once they are approved.  With cpu_approve=ask the command is
+confirmed on the terminal, and otherwise cpu_approve is a local
+command, say one asking for approval in a chat, that is given the host
+in CPU_HOST and the command in CPU_COMMAND and approves it by exiting
+zero.  Commands not approved are refused with status 77, as by a
+remote policy:
+
+	[production:vars]
+	cpu_approve=ask
+
+A remote that needs more than a host name can be kept as a profile in
+~/.config/cpu/profiles, a section of settings named by @<name> in
+place of the remote.  Besides the host, user and port, a profile may
+give arguments to ssh for that remote alone, instead of CPU_SSH_ARGS
+for all of them, map local directories to remote ones, and set any
+of cpu's flags, such as -sendenv or -env-file:
+
+	% cat ~/.config/cpu/profiles
+	[work]
+	host = build.corp.example.com
+	user = ato
+	ssh-args = -J bastion.corp.example.com
+	path = ~/work=/srv/src
+	env-file = /home/ato/.config/cpu/work.env
+	% cpu -r @work make
+
+A profile for a remote that is shared or otherwise less trusted can
+set trust = low, or -trust low be given for it.  cpu then gives the
+remote no way back into this machine: agent and X11 forwarding, remote
+port forwards, -mount, -sudo, which hands it the password, -watch,
+-pull and copying with cp without -push, which let it write into the
+working directory, and -autoforward and -rewrite-urls, which act on
+its output, are refused or turned off, as are forwardings from
+ssh_config(5), and variables whose names look like secrets, such as
+API_TOKEN, are kept from it.
+
+Scratch directories and kept overlays accumulate on the remote.  With
+-quota or CPU_QUOTA set to a size such as 20G, the least recently used
+ones are removed whenever a new one is made, keeping cpu's usage of a
+shared builder's disk within bounds.  Those still in use by a run or a
+queued job are left alone.  The status subcommand reports the current
+usage:
+
+	% cpu status
+
+To avoid surprising others on a shared builder, commands run with
+-presence are listed by the who subcommand for as long as they run,
+along with their directory, where every user of the remote can read
+them.  The wall subcommand leaves a short message that other cpu users
+see when they next run a command with -presence there:
+
+	% cpu -presence make -j
+	% cpu who
+	% cpu wall taking all cores for 20 minutes
+
+Long jobs can be left to run later.  With -at the command is queued on
+the remote to start at the given local time, and with -when-idle once
+the remote's load average has dropped below half its processors;
+either way cpu returns as soon as the job is queued.  The jobs
+subcommand lists the queued jobs and their state, or shows the output
+of the jobs named:
+
+	% cpu -at 02:00 -when-idle ./mach build
+	% cpu jobs
+	% cpu jobs 20261014-020000-4242
+
+The logs subcommand shows a job's output, and with -f follows it as
+it is written until the job ends.  Any number of terminals can watch
+a job this way, or teammates in a session shared with cpu share,
+without being able to send it input:
+
+	% cpu logs -f 20261014-020000-4242
+
+The output of a command can be piped to a command on another remote
+by giving the second remote and command after -then.  If the first
+remote can log in to the second one by itself, the output is sent
+there directly rather than through this machine.  As in a shell
+pipeline, the exit status is that of the second command:
+
+	% cpu -r builder 'tar cz -C obj dist' -then -r staging 'tar xz -C /srv/app'
+
+Editor plugins and other tools can embed cpu's remote parsing, path
+mapping and command execution with the package sny.no/cpu/rcpu rather
+than running the cpu binary.
+
+The checkout subcommand fetches a review into the remote checkout and
+checks it out in a worktree of its own under ~/.cache/cpu/checkout,
+which later commands from the same local directory then run in until
+checkout -clear.  Reviews are GitHub pull request numbers, Gerrit
+changes with their patch set, or any other ref; with -local the review
+is also fetched into the local repository as cpu/<name>:
+
+	% cpu checkout -local 1234
+	% cpu ./mach build
+	% cpu checkout -clear
+
+Profiles are taken on the remote with the perf subcommand, which runs
+perf(1) there and copies the profile back after perf record, and with
+the flamegraph subcommand, which profiles a command and draws a flame
+graph of it locally using inferno or FlameGraph's scripts, with the
+remote directory in the stacks replaced by the local one:
+
+	% cpu perf record -g ./obj/bin/xpcshell test.js
+	% cpu flamegraph -o build.svg make -j
+
+Go programs serving net/http/pprof on the remote are profiled with the
+pprof subcommand, which forwards the port, fetches the profile, and
+opens it in the local pprof web UI with the remote directory in source
+paths mapped to the local one:
+
+	% cpu pprof :6060 heap
+
+A session can be recorded for replaying later with -record, which
+writes its output and timing in asciinema's asciicast v2 format:
+
+	% cpu -record debug.cast gdb ./obj/bin/firefox
+	% asciinema play debug.cast
+
+The journal subcommand shows the remote's system log from journald, or
+from the syslog files where there is no journald, with journald's
+timestamps in the local time zone and entries coloured by priority.
+As with journalctl(1), -f keeps following the log and a unit can be
+given:
+
+	% cpu -r buildmachine journal -f docker
+
+For auditing long builds, -log appends everything the command writes
+to a file, each line marked with the time and the stream it was
+written to, and -log-input adds what was typed or piped to it:
+
+	% cpu -log build.log ./mach build
+
+To simply keep a copy of the output while watching it, -o writes
+everything the command prints to a file as well, and -e writes its
+standard error to a separate file instead:
+
+	% cpu -o build.log -e errors.log make
+
+A remote pseudo-terminal merges standard error into standard output.
+When standard error is redirected locally while another stream is a
+terminal, cpu instead passes the command's standard error through a
+named pipe and a second session, so that it still ends up where it
+was redirected:
+
+	% cpu make 2>errors.txt
+
+A small fleet of identical machines can be administered side by side
+with the panes subcommand, which opens a tmux(1) window with an
+interactive shell in the mapped directory on each remote.  Keystrokes
+go to the focused pane, or to all of them at once after pressing B
+following the tmux prefix, or from the start with -broadcast.  The
+status line shows which:
+
+	% cpu panes -broadcast builder1 builder2 builder3
+
+Long runs can report back when they are done.  Each -notify sink, or
+each in the comma-separated CPU_NOTIFY, is told the host, command,
+exit status, duration, and last lines of output of runs that fail,
+are tagged with -tag, or take longer than -notify-after: a URL is
+sent the details as JSON, slack:URL posts to a Slack incoming
+webhook, and mailto:address sends mail with sendmail(8):
+
+	% cpu -notify-after 10m -notify mailto:ato@example.com ./mach build
+
+cpu keeps a history of the commands it runs in
+~/.local/state/cpu/history, unless -no-history or CPU_NO_HISTORY is
+given.  While a command that has been run on the same remote and
+directory before is running, the terminal's title shows when it is
+expected to be done, and a note is printed afterwards if it took much
+longer than usual.
+
+With -anomaly warn, runs without a terminal that take far longer or
+write much more output than the same command usually does are
+reported while they run, as they may have hung or be filling a log.
+With -anomaly notify they are also sent to the -notify sinks, and
+with -anomaly kill the connection is closed and cpu exits with status
+124, as on -timeout.
+
+Per-host tuning can be kept in one place with rules in
+~/.config/cpu/rewrite, which rewrite the leading words of commands
+matching a pattern of shell globs.  A rule may be limited to remotes
+matching @host, and {nproc} and {memory}, in GiB, are replaced with
+what the remote has.  The first matching rule applies, unless
+-no-rewrite is given:
+
+	make -j* => make -j{nproc}
+	cargo build => cargo build --jobs {nproc}
+	@ci* ninja => NINJA_STATUS='[%f/%t] ' ninja
+
+Administrators of shared remotes can have a say in what runs on
+them by installing an executable ~/.config/cpu/remote-policy for the
+remote user.  It is run before each command with the command in
+CPU_COMMAND, the directory in CPU_DIR, and the variables cpu forwards.
+By exiting with a non-zero status it refuses the command, which makes
+cpu exit with status 77, and by printing dir=PATH or nice=N it runs
+the command in another directory or at a lower priority:
+
+	#!/bin/sh
+	case $CPU_COMMAND in
+	*make*) echo nice=10 ;;
+	esac
+
+For pair-debugging on a remote, the share subcommand starts a tmux(1)
+session there and prints a token with which a teammate who has an
+account on the remote joins it using the attach subcommand.  No SSH
+credentials change hands.  Only the users named after the flags may
+join, and they only watch the session unless -rw is given.  As tmux
+enforces this from version 3.3, sharing is refused with older ones.
+Sharing stops when the session ends or is detached from:
+
+	% cpu share alice
+	cpu: teammates attach with: cpu -r buildmachine attach -r 3f9a0c2e7d41b865
+	% cpu -r buildmachine attach -r 3f9a0c2e7d41b865
+*/
+package main
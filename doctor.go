@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Clock skew beyond which doctor warns, as make(1) and others comparing
+// the times of synchronised files get confused by it.
+const maxClockSkew = 2 * time.Second
+
+// checkup prints the outcome of each check doctor makes, with a fix for
+// those that fail, and remembers the exit status to give.
+type checkup struct {
+	code int
+}
+
+func (c *checkup) ok(what string, format string, a ...interface{}) {
+	fmt.Printf("ok    %s: %s\n", what, fmt.Sprintf(format, a...))
+}
+
+func (c *checkup) warn(what string, fix string, format string, a ...interface{}) {
+	fmt.Printf("warn  %s: %s\n      fix: %s\n", what, fmt.Sprintf(format, a...), fix)
+}
+
+func (c *checkup) fail(code int, what string, fix string, format string, a ...interface{}) {
+	fmt.Printf("FAIL  %s: %s\n      fix: %s\n", what, fmt.Sprintf(format, a...), fix)
+	if c.code == 0 {
+		c.code = code
+	}
+}
+
+// Checks that ssh(1) can be run and that an agent holds keys.
+func (c *checkup) local() bool {
+	path, err := exec.LookPath("ssh")
+	if err != nil {
+		c.fail(EX_CMDNFOUND, "ssh", "install the OpenSSH client and put it on the PATH", "%v", err)
+		return false
+	}
+	out, _ := exec.Command(path, "-V").CombinedOutput()
+	c.ok("ssh", "%s (%s)", strings.TrimSpace(string(out)), path)
+
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		c.warn("agent", `start one with eval "$(ssh-agent)" and add your key with ssh-add`,
+			"SSH_AUTH_SOCK is not set, so keys without a passphrase are needed")
+		return true
+	}
+	out, err = exec.Command("ssh-add", "-l").Output()
+	var exiterr *exec.ExitError
+	switch {
+	case err == nil:
+		c.ok("agent", "%d keys", bytes.Count(out, []byte("\n")))
+	case errors.As(err, &exiterr) && exiterr.ExitCode() == 1:
+		c.warn("agent", "add your key with ssh-add", "the agent holds no keys")
+	default:
+		c.warn("agent", `start a new one with eval "$(ssh-agent)"`, "cannot reach the agent at %s", os.Getenv("SSH_AUTH_SOCK"))
+	}
+	return true
+}
+
+// Checks that remote can be reached and logged in to without a
+// prompt, and that its shell, mapped directory and clock are as cpu
+// needs them.
+func (c *checkup) remote(remote string) {
+	fmt.Printf("%s:\n", colorHost(remote, os.Stdout))
+	r, err := rcpu.ParseRemote(remote)
+	if err != nil {
+		c.fail(EX_USAGE, "remote", "give it as [<user>@]<host>[#<port>][:<path>]", "%v", err)
+		return
+	}
+	saved := remotePort
+	remotePort = r.Port
+	defer func() { remotePort = saved }()
+	_, _, path := splitLoginPath(remote)
+	path = rcpu.MapPath(path)
+
+	conf := effectiveConfig(r.Login)
+	if conf == nil {
+		c.fail(EX_USAGE, "config", "fix the errors ssh -G "+r.Login+" reports", "ssh cannot read its configuration for %s", r.Login)
+		return
+	}
+	if conf["proxyjump"] != "" && conf["proxyjump"] != "none" || conf["proxycommand"] != "" {
+		c.ok("network", "reached through a proxy, so not tried directly")
+	} else {
+		addr := net.JoinHostPort(conf["hostname"], conf["port"])
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			c.fail(EX_NOHOST, "network", "check that the host is up and that sshd listens on port "+conf["port"], "%v", err)
+			return
+		}
+		conn.Close()
+		c.ok("network", "%s answers in %s", addr, time.Since(start).Round(time.Millisecond))
+	}
+
+	probe := fmt.Sprintf(`echo "shell=$SHELL"; command -v bash >/dev/null && echo bash=yes; `+
+		`echo "time=$(date +%%s)"; [ -d %s ] && echo dir=yes; true`, rcpu.Quote(path))
+	// options given first take precedence over the quiet of makeSshOptions
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=5", "-o", "LogLevel=ERROR"}
+	args = append(append(args, makeSshOptions()...), "-T", r.Login, probe)
+	var stderr bytes.Buffer
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = &stderr
+	before := time.Now()
+	out, err := cmd.Output()
+	after := time.Now()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		fix := "run ssh -v " + r.Login + " to see where it fails"
+		if strings.Contains(msg, "Permission denied") {
+			fix = "install your public key with ssh-copy-id " + r.Login + ", or add it to the agent with ssh-add"
+		} else if strings.Contains(msg, "Host key verification failed") {
+			fix = "accept the host key by running ssh " + r.Login + " once"
+		}
+		c.fail(EX_NOHOST, "login", fix, "%s", msg)
+		return
+	}
+	c.ok("login", "logged in as %s", conf["user"])
+
+	facts := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			facts[k] = v
+		}
+	}
+	if facts["bash"] == "yes" {
+		c.ok("shell", "%s, with bash", facts["shell"])
+	} else {
+		c.warn("shell", "install bash on the remote, as commands are run in it when the local shell is bash",
+			"%s, without bash for commands from a local bash", facts["shell"])
+	}
+	if facts["dir"] == "yes" {
+		c.ok("directory", "%s exists", path)
+	} else {
+		c.warn("directory", "push it with -sync, or give the path as "+r.Login+":<path>",
+			"%s does not exist", path)
+	}
+	if sec, err := strconv.ParseInt(facts["time"], 10, 64); err == nil {
+		mid := before.Add(after.Sub(before) / 2)
+		skew := time.Unix(sec, 0).Sub(mid).Round(time.Second)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxClockSkew {
+			c.warn("clock", "keep both clocks in sync with NTP, for example with timedatectl set-ntp true",
+				"off by %s", skew)
+		} else {
+			c.ok("clock", "off by less than %s", maxClockSkew)
+		}
+	}
+}
+
+// Returns the remotes given to a subcommand checking them, or else the
+// remote cpu would run commands on, or else every host of
+// ssh_config(5).  Groups are replaced by their remotes.
+func checkedRemotes(args []string) []string {
+	given := args
+	if len(given) == 0 && *remote != "" {
+		given = []string{*remote}
+	} else if len(given) == 0 {
+		if r := gitConfigRemote(); r != "" {
+			given = []string{r}
+		} else {
+			given = knownHosts()
+		}
+	}
+	var remotes []string
+	for _, r := range given {
+		if p := lookupProfile(r); p != nil {
+			r = p.remote()
+		}
+		if isGroup(r) {
+			remotes = append(remotes, groupMembers(r)...)
+		} else {
+			remotes = append(remotes, r)
+		}
+	}
+	return remotes
+}
+
+// doctor checks each link of the chain cpu relies on, from ssh(1) and
+// its agent here to the login, shell, mapped directory and clock of
+// each remote, and says how to fix what it finds wrong.
+func doctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s doctor [remote ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	c := &checkup{}
+	if !c.local() {
+		return c.code
+	}
+	for _, r := range checkedRemotes(fs.Args()) {
+		c.remote(r)
+	}
+	return c.code
+}
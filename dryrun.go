@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+var dryRun = flag.Bool("n", false,
+	"print the ssh command line and remote command instead of running them")
+
+// Characters that need no quoting for the shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Formats args as a command line that can be pasted into a shell.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if shellSafe.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = rcpu.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Prints how args would be run on login under path.  Nothing is
+// created on the remote, so the directories of -scratch, -mount,
+// -overlay and -sudo appear with placeholder names.
+func printDryRun(login string, path string, args []string) int {
+	switch {
+	case *scratch:
+		explainf("not pushing to a scratch directory in a dry run")
+		path = scratchRoot + "/XXXXXXXX"
+	case *mount:
+		explainf("not mounting the working directory in a dry run")
+		path = mountRoot + "/XXXXXXXX"
+	case *syncDir:
+		explainf("not pushing to %s in a dry run", path)
+	}
+	if *overlay != "" {
+		overlayDir = overlayRoot + "/XXXXXXXX"
+	}
+	if *sudo {
+		sudoDir = sudoRoot + "/XXXXXXXX"
+	}
+
+	path = rcpu.MapPath(path)
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	cmd := makeRemoteCmd(path, args)
+	fmt.Println(quoteArgs(append([]string{"ssh"}, append(makeSshArgs(login), cmd)...)))
+	fmt.Println(cmd)
+	return 0
+}
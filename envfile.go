@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var envFile = flag.String("env-file", "",
+	"forward the KEY=VALUE lines of `file` to the remote command")
+
+// Variables read from -env-file.
+var envFileVars []string
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Reads the variables in name, one KEY=VALUE assignment per line, as
+// in the .env files of docker(1) and others.  Blank lines and comments
+// are skipped, an export before the name is ignored, and values may be
+// in single or double quotes.
+func readEnvFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 0 || !envName.MatchString(line[:i]) {
+			return nil, fmt.Errorf("%s:%d: not a KEY=VALUE assignment", name, n)
+		}
+		value := line[i+1:]
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		} else if len(value) >= 2 && value[0] == '"' {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+		}
+		env = append(env, line[:i]+"="+value)
+	}
+	return env, s.Err()
+}
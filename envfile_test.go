@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTemp(t *testing.T, data string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"", nil},
+		{"# comment\n\n  \nA=1\n", []string{"A=1"}},
+		{"export B=two words\n", []string{"B=two words"}},
+		{"C='single $quoted \\n'\n", []string{`C=single $quoted \n`}},
+		{`D="double \"quoted\"\n"` + "\n", []string{"D=double \"quoted\"\n"}},
+		{"E=\nF=''\n", []string{"E=", "F="}},
+		{"G=a=b\n", []string{"G=a=b"}},
+		{"_H1=x\n", []string{"_H1=x"}},
+		{"I='unbalanced\n", []string{"I='unbalanced"}},
+	}
+	for _, tt := range tests {
+		got, err := readEnvFile(writeTemp(t, tt.data))
+		if err != nil {
+			t.Errorf("readEnvFile(%q): %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readEnvFile(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestReadEnvFileErrors(t *testing.T) {
+	for _, data := range []string{
+		"no assignment\n",
+		"1A=x\n",
+		"A-B=x\n",
+		"=x\n",
+		"A = x\n",
+		"A=\"unterminated\n",
+	} {
+		if env, err := readEnvFile(writeTemp(t, data)); err == nil {
+			t.Errorf("readEnvFile(%q) = %q, want error", data, env)
+		}
+	}
+	if _, err := readEnvFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("readEnvFile of a missing file succeeded")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var explain = flag.Bool("explain", false,
+	"describe each decision taken on the way to running the command")
+
+// Why each forwarded environment variable is passed on.
+var envReasons = map[string]string{
+	"TERM":  "so the remote knows the capabilities of the local terminal",
+	"PAGER": "so programs page output the same way as locally",
+	"LANG":  "so programs use the same locale as locally",
+	"CPU_":  "so remote scripts know which client and session the command is from",
+}
+
+// Describes one step of the pipeline when -explain is given.
+// At -vv the steps are logged instead.
+func explainf(format string, a ...interface{}) {
+	if *explain {
+		fmt.Fprintf(os.Stderr, "explain: %s\n", fmt.Sprintf(format, a...))
+	} else {
+		debugf(logSteps, "step", format, a...)
+	}
+}
+
+// Describes where the remote machine was taken from.
+func explainRemote() {
+	if !*explain {
+		return
+	}
+	from := "the CPU_REMOTE environment variable"
+	if remoteSource != "" {
+		from = remoteSource
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "r" {
+			from = "the -r flag"
+		}
+	})
+	explainf("remote %q taken from %s", *remote, from)
+}
+
+// Describes the environment variables forwarded by makeEnvironment.
+func explainEnvironment(env []string) {
+	for _, kv := range env {
+		name := kv[:strings.Index(kv, "=")]
+		if strings.HasPrefix(name, "LC_") {
+			name = "LANG"
+		} else if strings.HasPrefix(name, "CPU_") {
+			name = "CPU_"
+		}
+		if reason, ok := envReasons[name]; ok {
+			explainf("forwarding %s %s", kv, reason)
+		} else {
+			// values from -env-file may be secrets
+			explainf("forwarding %s from %s", name, *envFile)
+		}
+	}
+}
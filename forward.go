@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	localForwards  stringList
+	remoteForwards stringList
+	autoforward    = flag.Bool("autoforward", false,
+		"forward ports the remote command reports listening on to the same local ports")
+)
+
+func init() {
+	flag.Var(&localForwards, "L",
+		"forward a local port to the remote, as with ssh -L (repeatable)")
+	flag.Var(&remoteForwards, "R",
+		"forward a remote port to the local system, as with ssh -R (repeatable)")
+}
+
+// Lines announcing that a server is listening on a local port.  The
+// port must follow a local address or a URL, so that times such as
+// "started 10:45" are not taken for ports.
+var listeningPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:listening|serving|running|started|available)(?: on| at)?\s+(?:[a-z]+://)?(?:localhost|127\.0\.0\.1|0\.0\.0\.0|\[::\]|\[::1\]|\*):(\d{2,5})\b`),
+	regexp.MustCompile(`https?://(?:localhost|127\.0\.0\.1|0\.0\.0\.0|\[::1?\]):(\d{2,5})\b`),
+}
+
+func makeForwardArgs() []string {
+	var args []string
+	for _, spec := range localForwards {
+		args = append(args, "-L", unprivilegedForward(spec))
+	}
+	for _, spec := range remoteForwards {
+		args = append(args, "-R", spec)
+	}
+	return args
+}
+
+// Local ports forwarded in place of privileged ones this user may not
+// listen on, by the port asked for.
+var (
+	portMappingsMu sync.Mutex
+	portMappings   = map[int]int{}
+)
+
+// Returns the local port the given port is forwarded from, which is
+// the same port unless it was privileged.
+func mappedPort(port int) int {
+	portMappingsMu.Lock()
+	defer portMappingsMu.Unlock()
+	if alt, ok := portMappings[port]; ok {
+		return alt
+	}
+	return port
+}
+
+// [bind_address:]port:host:hostport -> bind_address, port, host:hostport
+//
+// Addresses may be IPv6 addresses in brackets.
+func splitLocalForward(spec string) (bind string, port string, target string) {
+	var fields []string
+	depth, start := 0, 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields, start = append(fields, spec[start:i]), i+1
+			}
+		}
+	}
+	fields = append(fields, spec[start:])
+	if len(fields) > 3 {
+		bind, fields = strings.TrimSuffix(strings.TrimPrefix(fields[0], "["), "]"), fields[1:]
+	}
+	return bind, fields[0], strings.Join(fields[1:], ":")
+}
+
+// Rewrites the ssh -L spec [bind_address:]port:host:hostport to listen
+// on an unprivileged port if its port is privileged and cannot be
+// listened on, saying which.  The port is 8000 higher, making 80 8080
+// and 443 8443, or any free port if that is taken.
+func unprivilegedForward(spec string) string {
+	bind, p, target := splitLocalForward(spec)
+	port, err := strconv.Atoi(p)
+	if target == "" || err != nil || port >= 1024 {
+		return spec
+	}
+	addr := bind
+	if addr == "" || addr == "*" {
+		addr = "localhost"
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(addr, p))
+	if err == nil {
+		l.Close()
+		return spec
+	} else if !errors.Is(err, os.ErrPermission) {
+		return spec
+	}
+
+	alt := port + 8000
+	if l, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(alt))); err == nil {
+		l.Close()
+	} else if l, err := net.Listen("tcp", net.JoinHostPort(addr, "0")); err == nil {
+		alt = l.Addr().(*net.TCPAddr).Port
+		l.Close()
+	} else {
+		return spec
+	}
+	portMappingsMu.Lock()
+	portMappings[port] = alt
+	portMappingsMu.Unlock()
+	fmt.Fprintf(os.Stderr, "%s: port %d is privileged, forwarding localhost:%d in its place\r\n", os.Args[0], port, alt)
+	spec = strconv.Itoa(alt) + ":" + target
+	if strings.Contains(bind, ":") {
+		spec = "[" + bind + "]:" + spec
+	} else if bind != "" {
+		spec = bind + ":" + spec
+	}
+	return spec
+}
+
+// A portForwarder watches output for servers announcing the port they
+// listen on and forwards each such port from the local system to the
+// remote over a separate ssh(1) connection.
+type portForwarder struct {
+	login string
+
+	mu      sync.Mutex
+	tunnels map[int]*exec.Cmd
+}
+
+func newPortForwarder(login string) *portForwarder {
+	return &portForwarder{login: login, tunnels: make(map[int]*exec.Cmd)}
+}
+
+// Returns a writer copying to w that scans the output for ports.
+func (f *portForwarder) watch(w io.Writer) io.Writer {
+	return &lineWatcher{w: w, fn: f.scan}
+}
+
+func (f *portForwarder) scan(line []byte) {
+	for _, re := range listeningPatterns {
+		for _, m := range re.FindAllSubmatch(line, -1) {
+			port, err := strconv.Atoi(string(m[1]))
+			if err == nil && port > 0 && port < 65536 {
+				f.forward(port)
+			}
+		}
+	}
+}
+
+func (f *portForwarder) forward(port int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tunnels[port]; ok {
+		return
+	}
+
+	spec := unprivilegedForward(fmt.Sprintf("%d:localhost:%d", port, port))
+	args := append(makeSshOptions(), "-N", "-o ExitOnForwardFailure=yes", "-L", spec, f.login)
+	cmd := exec.Command("ssh", args...)
+	if verbose >= logCommands {
+		log.Println(cmd)
+	}
+	f.tunnels[port] = cmd
+	if err := cmd.Start(); err != nil {
+		log.Printf("autoforward: port %d: %v", port, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: forwarding localhost:%d to %s\r\n", os.Args[0], port, colorHost(f.login, os.Stderr))
+}
+
+// Tears down all forwards.
+func (f *portForwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, cmd := range f.tunnels {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}
+}
+
+// A lineWatcher is a writer that passes everything through to w and
+// calls fn with each complete line written.
+type lineWatcher struct {
+	w   io.Writer
+	fn  func(line []byte)
+	buf []byte
+}
+
+func (lw *lineWatcher) Write(p []byte) (int, error) {
+	n, err := lw.w.Write(p)
+	lw.buf = append(lw.buf, p[:n]...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.fn(lw.buf[:i])
+		lw.buf = lw.buf[i+1:]
+	}
+	return n, err
+}
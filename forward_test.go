@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestListeningPatterns(t *testing.T) {
+	tests := []struct {
+		line  string
+		ports []int
+	}{
+		{"Listening on localhost:8080", []int{8080}},
+		{"listening on tcp://0.0.0.0:9000", []int{9000}},
+		{"Server running at http://127.0.0.1:3000/", []int{3000, 3000}},
+		{"running on *:5000", []int{5000}},
+		{"Serving on [::]:8000", []int{8000}},
+		{"started 10:45", nil},
+		{"Started 10:45 on port 99", nil},
+		{"listening on example.com:8080", nil},
+	}
+	for _, tt := range tests {
+		var ports []int
+		for _, re := range listeningPatterns {
+			for _, m := range re.FindAllStringSubmatch(tt.line, -1) {
+				port, _ := strconv.Atoi(m[1])
+				ports = append(ports, port)
+			}
+		}
+		if !reflect.DeepEqual(ports, tt.ports) {
+			t.Errorf("ports in %q = %v, want %v", tt.line, ports, tt.ports)
+		}
+	}
+}
+func TestSplitLocalForward(t *testing.T) {
+	tests := []struct {
+		spec               string
+		bind, port, target string
+	}{
+		{"8080:localhost:80", "", "8080", "localhost:80"},
+		{"127.0.0.1:8080:localhost:80", "127.0.0.1", "8080", "localhost:80"},
+		{"*:5432:db.internal:5432", "*", "5432", "db.internal:5432"},
+		{"[::1]:8080:localhost:80", "::1", "8080", "localhost:80"},
+		{"8080:[::1]:80", "", "8080", "[::1]:80"},
+		{"[::1]:8080:[fe80::1]:80", "::1", "8080", "[fe80::1]:80"},
+		{"8080", "", "8080", ""},
+	}
+	for _, tt := range tests {
+		bind, port, target := splitLocalForward(tt.spec)
+		if bind != tt.bind || port != tt.port || target != tt.target {
+			t.Errorf("splitLocalForward(%q) = %q, %q, %q; want %q, %q, %q",
+				tt.spec, bind, port, target, tt.bind, tt.port, tt.target)
+		}
+	}
+}
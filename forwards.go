@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Name of the file in the local directory listing the project's sets
+// of forwards, one per line:
+//
+//	name forward...
+//
+// where each forward is a port forwarded to the same port on the
+// remote, or [bind_address:]port:host:hostport as with ssh -L.
+const forwardsFile = ".cpuforwards"
+
+// forwardSet is a named set of local forwards kept up in the
+// background by a master connection of its own.
+type forwardSet struct {
+	name  string
+	specs []string
+}
+
+func readForwardSets(name string) ([]forwardSet, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sets []forwardSet
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: missing forwards", name, n)
+		}
+		set := forwardSet{name: strings.TrimSuffix(fields[0], ":")}
+		for _, spec := range fields[1:] {
+			if _, err := strconv.Atoi(spec); err == nil {
+				spec = spec + ":localhost:" + spec
+			}
+			set.specs = append(set.specs, spec)
+		}
+		sets = append(sets, set)
+	}
+	return sets, s.Err()
+}
+
+// Control socket of the master connection keeping up the set for the
+// project in dir.  It is named by a hash, like the sockets of shared
+// connections, so that it stays within the length limit of sockets.
+func (set forwardSet) socket(login, dir string) string {
+	sum := sha1.Sum([]byte(login + "\x00" + dir + "\x00" + set.name))
+	return filepath.Join(controlDir, fmt.Sprintf("fwd-%x", sum[:8]))
+}
+
+// Runs ssh(1) with args on the master connection of set.
+func (set forwardSet) ssh(login, dir string, args ...string) *exec.Cmd {
+	// options given first take precedence over those of connection sharing
+	opts := []string{"-o", "ControlPath=" + set.socket(login, dir)}
+	opts = append(append(opts, args...), makeSshOptions()...)
+	return exec.Command("ssh", append(opts, login)...)
+}
+
+func (set forwardSet) up(login, dir string) error {
+	args := []string{"-o", "ControlMaster=yes", "-o", "ControlPersist=yes",
+		"-o", "ExitOnForwardFailure=yes", "-f", "-N"}
+	for _, spec := range set.specs {
+		args = append(args, "-L", unprivilegedForward(spec))
+	}
+	cmd := set.ssh(login, dir, args...)
+	explainf("executing %s", cmd)
+	return cmd.Run()
+}
+
+func (set forwardSet) down(login, dir string) error {
+	return set.ssh(login, dir, "-O", "exit").Run()
+}
+
+func (set forwardSet) isUp(login, dir string) bool {
+	return set.ssh(login, dir, "-O", "check").Run() == nil
+}
+
+// forwards brings the project's sets of forwards up or down in the
+// background, independently of any command, or shows which are up.
+func forwards(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("forwards", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s forwards [status | up [name ...] | down [name ...]]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	action := "status"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	if action != "status" && action != "up" && action != "down" {
+		fs.Usage()
+		return EX_USAGE
+	}
+	if controlDir == "" {
+		exit(EX_USAGE, "forwards need connection sharing, which is disabled")
+	}
+	dir, _ := os.Getwd()
+	sets, err := readForwardSets(filepath.Join(dir, forwardsFile))
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	if fs.NArg() > 1 {
+		var picked []forwardSet
+		for _, name := range fs.Args()[1:] {
+			found := false
+			for _, set := range sets {
+				if set.name == name {
+					picked, found = append(picked, set), true
+				}
+			}
+			if !found {
+				exit(EX_USAGE, "no forwards named %s in %s", name, forwardsFile)
+			}
+		}
+		sets = picked
+	}
+
+	code := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if action == "status" {
+		fmt.Fprintln(tw, "NAME\tSTATE\tFORWARDS")
+	}
+	for _, set := range sets {
+		switch up := set.isUp(login, dir); {
+		case action == "status":
+			state := "down"
+			if up {
+				state = "up"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", set.name, state, strings.Join(set.specs, " "))
+		case action == "up" && !up:
+			if err := set.up(login, dir); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: forwards %s: %v\n", os.Args[0], set.name, err)
+				code = EX_NOHOST
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s: forwarding %s to %s\n", os.Args[0], strings.Join(set.specs, ", "), colorHost(login, os.Stderr))
+		case action == "down" && up:
+			if err := set.down(login, dir); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: forwards %s: %v\n", os.Args[0], set.name, err)
+				code = EX_NOHOST
+			}
+		}
+	}
+	tw.Flush()
+	return code
+}
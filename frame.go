@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+)
+
+var frame = flag.Bool("frame", false,
+	"strip what the remote's login and rc files print before the command from output that is not a terminal")
+
+// Which of the remote's standard streams are framed by a marker line
+// printed right before the command runs.
+var frameStdout, frameStderr bool
+
+// Line printed by the remote before the command, unique to the session
+// so that no output can be mistaken for it.
+func frameMarker() string {
+	return "cpu-begin-" + sessionID
+}
+
+// Prints the marker on the framed streams.  It is printed in two parts
+// so that shells tracing their commands, as with set -x in an rc file,
+// do not print the marker itself.
+func makeFramingCmd() string {
+	cmd := ""
+	if frameStdout {
+		cmd += "printf '%s%s\\n' cpu-begin- " + sessionID + "; "
+	}
+	if frameStderr {
+		cmd += "printf '%s%s\\n' cpu-begin- " + sessionID + " >&2; "
+	}
+	return cmd
+}
+
+// Frames the streams that are not terminals, where anything the remote
+// prints before the command would corrupt output meant for programs.
+// A stream shared with the remote pseudo-terminal is framed through
+// standard output.  The returned function writes what was held back
+// if the marker never came, as when the command could not be run.
+func frameOutput(stdout, stderr io.Writer) (io.Writer, io.Writer, func()) {
+	if !*frame || windowsRemote() {
+		return stdout, stderr, func() {}
+	}
+	frameStdout = !isatty(os.Stdout)
+	frameStderr = !isatty(os.Stderr) && (!wantTTY() || needStderrPipe())
+	var filters []*frameFilter
+	if frameStdout {
+		f := &frameFilter{w: stdout, marker: []byte(frameMarker())}
+		filters, stdout = append(filters, f), f
+		explainf("standard output is not a terminal, so dropping what the remote prints before the command")
+	}
+	if frameStderr {
+		f := &frameFilter{w: stderr, marker: []byte(frameMarker())}
+		filters, stderr = append(filters, f), f
+		explainf("standard error is not a terminal, so dropping what the remote prints to it before the command")
+	}
+	return stdout, stderr, func() {
+		for _, f := range filters {
+			f.flush()
+		}
+	}
+}
+
+// frameFilter holds back output until the marker line, which it drops
+// along with everything before it.
+type frameFilter struct {
+	w      io.Writer
+	marker []byte
+	held   []byte
+	found  bool
+}
+
+func (f *frameFilter) Write(p []byte) (int, error) {
+	if f.found {
+		return f.w.Write(p)
+	}
+	f.held = append(f.held, p...)
+	i := bytes.Index(f.held, f.marker)
+	if i < 0 {
+		return len(p), nil
+	}
+	end := bytes.IndexByte(f.held[i:], '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	if i > 0 {
+		debugf(logCommands, "frame", "dropped %q printed before the command", f.held[:i])
+	}
+	rest := f.held[i+end+1:]
+	f.found, f.held = true, nil
+	if len(rest) > 0 {
+		if _, err := f.w.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *frameFilter) flush() {
+	if !f.found && len(f.held) > 0 {
+		f.w.Write(f.held)
+	}
+	f.held = nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameFilter(t *testing.T) {
+	tests := []struct {
+		writes []string
+		flush  bool
+		want   string
+	}{
+		{[]string{"motd\ncpu-begin-X\nout\n"}, false, "out\n"},
+		{[]string{"cpu-begin-X\n", "out\n"}, false, "out\n"},
+		{[]string{"motd\ncpu-be", "gin-", "X", "\nout", "\nmore\n"}, false, "out\nmore\n"},
+		{[]string{"motd\n", "cpu-begin-X"}, false, ""},
+		{[]string{"cpu-begin-X\n"}, true, ""},
+		{[]string{"ssh: connect to host x port 22\n"}, false, ""},
+		{[]string{"ssh: connect to host x port 22\n", "closed\n"}, true, "ssh: connect to host x port 22\nclosed\n"},
+		{[]string{"cpu-begin-Y\nout\n"}, true, "cpu-begin-Y\nout\n"},
+		{[]string{"cpu-begin-X\nout\ncpu-begin-X\n"}, true, "out\ncpu-begin-X\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		f := &frameFilter{w: &buf, marker: []byte("cpu-begin-X")}
+		for _, s := range tt.writes {
+			if n, err := f.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("%q: Write(%q) = %d, %v", tt.writes, s, n, err)
+			}
+		}
+		if tt.flush {
+			f.flush()
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%q: wrote %q, want %q", tt.writes, got, tt.want)
+		}
+	}
+}
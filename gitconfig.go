@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// Returns the remote set for the git repository of the working
+// directory with git config cpu.remote, or "" outside a repository or
+// without one.
+func gitConfigRemote() string {
+	out, err := exec.Command("git", "config", "--get", "cpu.remote").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var noHistory = flag.Bool("no-history", os.Getenv("CPU_NO_HISTORY") != "",
+	"do not add the command to the history of runs kept to estimate how long commands take")
+
+// run is an entry in the history of commands run with cpu.
+type run struct {
+	when     time.Time
+	login    string
+	dir      string
+	code     int
+	duration time.Duration
+	command  string
+	output   int64 // bytes written, or -1 if unknown
+}
+
+// File keeping the history of runs, one per line with tab-separated
+// fields.
+func historyFile() string {
+	return stateFile("history")
+}
+
+func readHistory() []run {
+	data, err := os.ReadFile(historyFile())
+	if err != nil {
+		return nil
+	}
+	var runs []run
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) < 6 {
+			continue
+		}
+		sec, err1 := strconv.ParseInt(f[0], 10, 64)
+		code, err2 := strconv.Atoi(f[3])
+		d, err3 := time.ParseDuration(f[4])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		output := int64(-1)
+		if len(f) > 6 {
+			if n, err := strconv.ParseInt(f[6], 10, 64); err == nil {
+				output = n
+			}
+		}
+		runs = append(runs, run{time.Unix(sec, 0), f[1], f[2], code, d, f[5], output})
+	}
+	return runs
+}
+
+func recordRun(r run) {
+	if *noHistory {
+		return
+	}
+	name := historyFile()
+	os.MkdirAll(filepath.Dir(name), 0700)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%d\t%s\t%s\t%d\t%v\t%s\t%d\n", r.when.Unix(), r.login, r.dir, r.code,
+		r.duration.Round(time.Millisecond), strings.ReplaceAll(r.command, "\t", " "), r.output)
+}
+
+// Number of past runs the prediction is based on.
+const historyWindow = 20
+
+// Returns the last successful runs of command on login in dir.
+func pastRuns(login string, dir string, command string) []run {
+	var runs []run
+	for _, r := range readHistory() {
+		if r.login == login && r.dir == dir && r.command == command && r.code == 0 {
+			runs = append(runs, r)
+		}
+	}
+	if len(runs) > historyWindow {
+		runs = runs[len(runs)-historyWindow:]
+	}
+	return runs
+}
+
+// Returns how long the command used to take when it succeeded on login
+// in dir, as the median of the last runs, and how many runs that is.
+func typicalDuration(login string, dir string, command string) (time.Duration, int) {
+	var ds []time.Duration
+	for _, r := range pastRuns(login, dir, command) {
+		ds = append(ds, r.duration)
+	}
+	if len(ds) == 0 {
+		return 0, 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2], len(ds)
+}
+
+// Shows when the command is expected to finish in the terminal's title,
+// based on earlier runs of it.  The title is only set before the
+// command starts and cleared after it ends, so as not to break up the
+// escape sequences of a remote terminal sharing it.  The returned
+// function clears it and notes if this run was much slower than usual.
+func showETA(login string, dir string, command string) func(took time.Duration) {
+	typical, n := typicalDuration(login, dir, command)
+	if n == 0 {
+		return func(time.Duration) {}
+	}
+	explainf("the command took %v in the last %d successful runs", typical.Round(time.Second), n)
+
+	title := isatty(os.Stderr)
+	if title {
+		done := time.Now().Add(typical).Format("15:04")
+		fmt.Fprintf(os.Stderr, "\x1b]2;cpu: usually takes %v, done by %s\a", typical.Round(time.Second), done)
+	}
+	return func(took time.Duration) {
+		if title {
+			fmt.Fprint(os.Stderr, "\x1b]2;\a")
+		}
+		if n >= 3 && took > typical*3/2 && took-typical > 10*time.Second {
+			fmt.Fprintf(os.Stderr, "%s: took %v, usually %v\n", os.Args[0], took.Round(time.Second), typical.Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Sets CPU_REMOTE from the nearest .cpu file at or above the working
+// directory, whose first line that is neither empty, a comment, nor
+// an assignment is the remote of the project.  The value it replaces
+// is put back on leaving the project, unless CPU_REMOTE was changed
+// since.
+const posixHook = `_cpu_hook() {
+	local d=$PWD r=
+	while :; do
+		if [ -f "$d/.cpu" ]; then
+			r=$(sed -n '/^[[:space:]]*#/d; /^[[:space:]]*CPU_[A-Z0-9_]*=/d; /[^[:space:]]/{p;q;}' "$d/.cpu")
+			break
+		fi
+		[ "$d" = / ] && break
+		d=${d%/*}
+		d=${d:-/}
+	done
+	if [ -n "$r" ]; then
+		if [ "$CPU_REMOTE" != "$r" ]; then
+			[ -n "${_CPU_HOOK_REMOTE+x}" ] || _CPU_HOOK_PREV=$CPU_REMOTE
+			export CPU_REMOTE=$r
+			_CPU_HOOK_REMOTE=$r
+		fi
+	elif [ -n "${_CPU_HOOK_REMOTE+x}" ]; then
+		if [ "$CPU_REMOTE" = "$_CPU_HOOK_REMOTE" ]; then
+			if [ -n "$_CPU_HOOK_PREV" ]; then
+				export CPU_REMOTE=$_CPU_HOOK_PREV
+			else
+				unset CPU_REMOTE
+			fi
+		fi
+		unset _CPU_HOOK_REMOTE _CPU_HOOK_PREV
+	fi
+}
+`
+
+const bashHook = posixHook + `case ";$PROMPT_COMMAND;" in
+*";_cpu_hook;"*) ;;
+*) PROMPT_COMMAND="_cpu_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+`
+
+const zshHook = posixHook + `autoload -Uz add-zsh-hook
+add-zsh-hook chpwd _cpu_hook
+_cpu_hook
+`
+
+const fishHook = `function _cpu_hook --on-variable PWD
+	set -l d $PWD
+	set -l r
+	while true
+		if test -f "$d/.cpu"
+			set r (sed -n '/^[[:space:]]*#/d; /^[[:space:]]*CPU_[A-Z0-9_]*=/d; /[^[:space:]]/{p;q;}' "$d/.cpu")
+			break
+		end
+		test "$d" = /; and break
+		set d (string replace -r '/[^/]*$' '' -- $d)
+		test -n "$d"; or set d /
+	end
+	if test -n "$r"
+		if test "$CPU_REMOTE" != "$r"
+			set -q _CPU_HOOK_REMOTE; or set -g _CPU_HOOK_PREV $CPU_REMOTE
+			set -gx CPU_REMOTE $r
+			set -g _CPU_HOOK_REMOTE $r
+		end
+	else if set -q _CPU_HOOK_REMOTE
+		if test "$CPU_REMOTE" = "$_CPU_HOOK_REMOTE"
+			if test -n "$_CPU_HOOK_PREV"
+				set -gx CPU_REMOTE $_CPU_HOOK_PREV
+			else
+				set -e CPU_REMOTE
+			end
+		end
+		set -e _CPU_HOOK_REMOTE
+		set -e _CPU_HOOK_PREV
+	end
+end
+_cpu_hook
+`
+
+// hook writes shell code that sets CPU_REMOTE from the .cpu file of
+// the project the working directory is in, to be evaluated from the
+// shell's rc file.
+func hook(args []string) int {
+	fs := flag.NewFlagSet("hook", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s hook bash | zsh | fish\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return EX_USAGE
+	}
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Print(bashHook)
+	case "zsh":
+		fmt.Print(zshHook)
+	case "fish":
+		fmt.Print(fishHook)
+	default:
+		fs.Usage()
+		return EX_USAGE
+	}
+	return 0
+}
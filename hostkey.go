@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What to do when the host key of a known remote has changed, from
+// CPU_HOSTKEY_POLICY: "prompt" (the default) asks, "abort" refuses to
+// connect, "update" replaces the known key, and "restricted" connects
+// once without trusting the new key.
+func hostKeyPolicy() string {
+	if p := os.Getenv("CPU_HOSTKEY_POLICY"); p != "" {
+		return p
+	}
+	return "prompt"
+}
+
+var (
+	// Extra options for ssh(1) decided while checking the host key.
+	hostKeyOptions []string
+
+	// Whether the host key has been checked during this invocation.
+	hostKeyChecked bool
+)
+
+// Checks whether the connection to login failed because its host key
+// changed and, if so, guides the user through it according to the
+// policy.  Returns true if connecting should be attempted again.
+func remediateHostKey(login string) bool {
+	if hostKeyChecked {
+		return false
+	}
+	hostKeyChecked = true
+
+	probe := exec.Command("ssh", append(makeSshOptions(),
+		"-o", "BatchMode=yes", "-o", "ControlPath=none", "-o", "LogLevel=ERROR",
+		login, "true")...)
+	var stderr bytes.Buffer
+	probe.Stderr = &stderr
+	probe.Run()
+	if !strings.Contains(stderr.String(), "REMOTE HOST IDENTIFICATION HAS CHANGED") {
+		return false
+	}
+
+	conf := effectiveConfig(login)
+	host, port := conf["hostname"], conf["port"]
+	name := host
+	if port != "" && port != "22" {
+		name = fmt.Sprintf("[%s]:%s", host, port)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: the host key of %s has changed!\n", os.Args[0], login)
+	fmt.Fprintln(os.Stderr, "This could mean someone is intercepting the connection, or that the host was reinstalled.")
+	if old := knownFingerprints(name); old != "" {
+		fmt.Fprintf(os.Stderr, "\nknown key:\n%s", old)
+	}
+	if key := scannedFingerprints(host, port); key != "" {
+		fmt.Fprintf(os.Stderr, "\npresented key:\n%s", key)
+	}
+	if seen, ok := lastSeen(login); ok {
+		fmt.Fprintf(os.Stderr, "\nlast connected %s ago, on %s\n",
+			time.Since(seen).Round(time.Minute), seen.Format(time.RFC1123))
+	}
+	fmt.Fprintln(os.Stderr)
+
+	choice := hostKeyPolicy()
+	if choice == "prompt" {
+		choice = promptHostKey()
+	}
+	switch choice {
+	case "update":
+		if err := exec.Command("ssh-keygen", "-R", name).Run(); err != nil {
+			exit(EX_NOHOST, "ssh-keygen -R %s: %v", name, err)
+		}
+		hostKeyOptions = []string{"-o", "StrictHostKeyChecking=accept-new"}
+		return true
+	case "restricted":
+		// ssh disables password authentication and all forwarding
+		// when connecting to a host with a mismatching key
+		fmt.Fprintln(os.Stderr, "connecting once without forwarding or password authentication")
+		hostKeyOptions = []string{"-o", "StrictHostKeyChecking=no"}
+		controlDir = ""
+		return true
+	default:
+		exit(EX_NOHOST, "host key verification failed for %s", login)
+		return false
+	}
+}
+
+func promptHostKey() string {
+	tty, err := os.Open(consoleName)
+	if err != nil {
+		return "abort"
+	}
+	defer tty.Close()
+
+	fmt.Fprint(os.Stderr, "[a]bort, accept and [u]pdate known_hosts, or connect [r]estricted? ")
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "u", "update":
+		return "update"
+	case "r", "restricted":
+		return "restricted"
+	default:
+		return "abort"
+	}
+}
+
+// Fingerprints of the keys recorded for name in known_hosts.
+func knownFingerprints(name string) string {
+	out, _ := exec.Command("ssh-keygen", "-l", "-F", name).Output()
+	var b strings.Builder
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			fmt.Fprintf(&b, "\t%s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// Fingerprints of the keys the host presents now.
+func scannedFingerprints(host, port string) string {
+	scan := exec.Command("ssh-keyscan", "-p", port, host)
+	keys, err := scan.Output()
+	if err != nil || len(keys) == 0 {
+		return ""
+	}
+	fp := exec.Command("ssh-keygen", "-l", "-f", "-")
+	fp.Stdin = bytes.NewReader(keys)
+	out, _ := fp.Output()
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fmt.Fprintf(&b, "\t%s\n", line)
+	}
+	return b.String()
+}
+
+// Path of the named file in cpu's local state directory.
+func stateFile(name string) string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "cpu", name)
+}
+
+// Path of the named file in cpu's local configuration directory.
+func configFile(name string) string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "cpu", name)
+}
+
+// File recording when each remote was last connected to successfully.
+func seenFile() string {
+	return stateFile("seen")
+}
+
+func readSeen() map[string]time.Time {
+	seen := make(map[string]time.Time)
+	data, err := os.ReadFile(seenFile())
+	if err != nil {
+		return seen
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if sec, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			seen[fields[0]] = time.Unix(sec, 0)
+		}
+	}
+	return seen
+}
+
+func lastSeen(login string) (time.Time, bool) {
+	t, ok := readSeen()[login]
+	return t, ok
+}
+
+// Notes that login was connected to successfully just now.
+func recordSeen(login string) {
+	seen := readSeen()
+	seen[login] = time.Now()
+
+	var b strings.Builder
+	for l, t := range seen {
+		fmt.Fprintf(&b, "%s %d\n", l, t.Unix())
+	}
+	name := seenFile()
+	os.MkdirAll(filepath.Dir(name), 0700)
+	os.WriteFile(name, []byte(b.String()), 0600)
+}
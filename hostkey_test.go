@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadSeen(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	os.MkdirAll(filepath.Dir(seenFile()), 0700)
+	data := "buildmachine 1700000000\n" +
+		"ato@[::1] 1700000060\n" +
+		"\n" +
+		"malformed\n" +
+		"notanumber abc\n" +
+		"too many 1 fields\n"
+	if err := os.WriteFile(seenFile(), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := readSeen()
+	want := map[string]time.Time{
+		"buildmachine": time.Unix(1700000000, 0),
+		"ato@[::1]":    time.Unix(1700000060, 0),
+	}
+	if len(seen) != len(want) {
+		t.Errorf("readSeen() = %v, want %v", seen, want)
+	}
+	for login, w := range want {
+		if got, ok := seen[login]; !ok || !got.Equal(w) {
+			t.Errorf("readSeen()[%q] = %v, %v; want %v", login, got, ok, w)
+		}
+	}
+}
+
+func TestRecordSeen(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	if _, ok := lastSeen("buildmachine"); ok {
+		t.Fatal("lastSeen before recordSeen reports a time")
+	}
+	before := time.Now().Add(-time.Second)
+	recordSeen("buildmachine")
+	recordSeen("builder2")
+	for _, login := range []string{"buildmachine", "builder2"} {
+		if got, ok := lastSeen(login); !ok || got.Before(before) {
+			t.Errorf("lastSeen(%q) = %v, %v; want after %v", login, got, ok, before)
+		}
+	}
+}
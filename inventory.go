@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+var inventoryFile = flag.String("inventory", os.Getenv("CPU_INVENTORY"),
+	"resolve groups and hosts from the Ansible inventory `file`, in INI format")
+
+// inventory is an Ansible inventory in INI format: hosts under the
+// [group] sections, the variables of a group's hosts under
+// [group:vars], and the groups a group is made of under
+// [group:children].  Hosts outside any section are in the group
+// ungrouped, and every host is in the group all.
+type inventory struct {
+	name      string
+	all       []string
+	hosts     map[string][]string
+	children  map[string][]string
+	hostVars  map[string]map[string]string
+	groupVars map[string]map[string]string
+}
+
+func readInventory(name string) (*inventory, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	inv := &inventory{
+		name:      name,
+		hosts:     map[string][]string{},
+		children:  map[string][]string{},
+		hostVars:  map[string]map[string]string{},
+		groupVars: map[string]map[string]string{},
+	}
+	group, kind := "ungrouped", ""
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: malformed section %s", name, n, line)
+			}
+			group, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			continue
+		}
+		fields := strings.Fields(line)
+		switch kind {
+		case "vars":
+			k, v, _ := strings.Cut(line, "=")
+			if inv.groupVars[group] == nil {
+				inv.groupVars[group] = map[string]string{}
+			}
+			inv.groupVars[group][strings.TrimSpace(k)] = unquoteVar(strings.TrimSpace(v))
+		case "children":
+			inv.children[group] = append(inv.children[group], fields[0])
+		default:
+			host := fields[0]
+			inv.hosts[group] = append(inv.hosts[group], host)
+			if inv.hostVars[host] == nil {
+				inv.all = append(inv.all, host)
+				inv.hostVars[host] = map[string]string{}
+			}
+			for _, kv := range fields[1:] {
+				if k, v, ok := strings.Cut(kv, "="); ok {
+					inv.hostVars[host][k] = unquoteVar(v)
+				}
+			}
+		}
+	}
+	return inv, s.Err()
+}
+
+func unquoteVar(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// Returns the hosts of group and of the groups it is made of, in the
+// order they are listed.
+func (inv *inventory) members(group string) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	var walk func(g string, depth int)
+	walk = func(g string, depth int) {
+		if depth > 16 {
+			return
+		}
+		list := inv.hosts[g]
+		if g == "all" {
+			list = inv.all
+		}
+		for _, h := range list {
+			if !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+		for _, c := range inv.children[g] {
+			walk(c, depth+1)
+		}
+	}
+	walk(group, 0)
+	return hosts
+}
+
+// Returns the value of the variable k for host, set on the host
+// itself or else on a group it is in.  As with Ansible, a child group
+// takes precedence over its parents, and of groups at the same depth
+// the last in alphabetical order wins.
+func (inv *inventory) lookup(host, k string) string {
+	if v, ok := inv.hostVars[host][k]; ok {
+		return v
+	}
+	var groups []string
+	for g, vars := range inv.groupVars {
+		if _, ok := vars[k]; ok && g != "all" && contains(inv.members(g), host) {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == 0 {
+		return inv.groupVars["all"][k]
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		di, dj := inv.depth(groups[i], 0), inv.depth(groups[j], 0)
+		if di != dj {
+			return di > dj
+		}
+		return groups[i] > groups[j]
+	})
+	return inv.groupVars[groups[0]][k]
+}
+
+// Returns how many levels of [group:children] sections group is
+// nested below all.
+func (inv *inventory) depth(group string, n int) int {
+	if n > 16 {
+		return n
+	}
+	d := 1
+	for parent, children := range inv.children {
+		if contains(children, group) {
+			if pd := inv.depth(parent, n+1) + 1; pd > d {
+				d = pd
+			}
+		}
+	}
+	return d
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns host as a remote of the form [<user>@]<host>[#<port>], from
+// its ansible_host, ansible_user and ansible_port.
+func (inv *inventory) remote(host string) string {
+	addr := host
+	if h := inv.lookup(host, "ansible_host"); h != "" {
+		addr = h
+	}
+	if strings.Contains(addr, ":") {
+		addr = "[" + addr + "]"
+	}
+	if u := inv.lookup(host, "ansible_user"); u != "" {
+		addr = u + "@" + addr
+	}
+	if p := inv.lookup(host, "ansible_port"); p != "" {
+		addr += "#" + p
+	}
+	return addr
+}
+
+// Reads the -inventory, or returns nil without one.
+func loadInventory() *inventory {
+	if *inventoryFile == "" {
+		return nil
+	}
+	inv, err := readInventory(*inventoryFile)
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	return inv
+}
+
+// Returns the remotes of the group in the -inventory, if it has one by
+// that name.
+func inventoryGroup(name string) ([]string, bool) {
+	inv := loadInventory()
+	if inv == nil {
+		return nil, false
+	}
+	hosts := inv.members(name)
+	if len(hosts) == 0 {
+		return nil, false
+	}
+	remotes := make([]string, len(hosts))
+	for i, h := range hosts {
+		remotes[i] = inv.remote(h)
+	}
+	explainf("group %s taken from %s", name, inv.name)
+	return remotes, true
+}
+
+// <host>[:<path>] -> the host's remote in the -inventory, with path
+//
+// Remotes that are not a host in the inventory are left as they are.
+func resolveInventoryHost(remote string) string {
+	inv := loadInventory()
+	if inv == nil {
+		return remote
+	}
+	host, path, hasPath := strings.Cut(remote, ":")
+	if _, ok := inv.hostVars[host]; !ok {
+		return remote
+	}
+	r := inv.remote(host)
+	explainf("host %s is %s in %s", host, r, inv.name)
+	if hasPath {
+		return r + ":" + path
+	}
+	return r
+}
+
+// Returns the host of the inventory that remote connects to, as
+// resolved from a group or a host name.
+func (inv *inventory) findHost(remote string) (string, bool) {
+	r, err := rcpu.ParseRemote(remote)
+	if err != nil {
+		return "", false
+	}
+	for _, h := range inv.all {
+		hr, err := rcpu.ParseRemote(inv.remote(h))
+		if err == nil && hr.Login == r.Login && hr.Port == r.Port {
+			return h, true
+		}
+	}
+	return "", false
+}
+
+// Placeholders in the command for the variables of the host it is run
+// on, as in ./deploy --region {host.region}.
+var hostPlaceholder = regexp.MustCompile(`\{host\.[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// Replaces the {host.<name>} placeholders in cmd by the variables of
+// host, quoted for the remote shell.
+func (inv *inventory) expandHostVars(host, cmd string) (string, error) {
+	var err error
+	expanded := hostPlaceholder.ReplaceAllStringFunc(cmd, func(p string) string {
+		k := p[len("{host.") : len(p)-1]
+		v := inv.lookup(host, k)
+		if v == "" && err == nil {
+			err = fmt.Errorf("%s has no variable %s in %s", host, k, inv.name)
+		}
+		return rcpu.Quote(v)
+	})
+	return expanded, err
+}
+
+// Fills in the {host.<name>} placeholders in cmd with the variables of
+// the host of the -inventory that remote is.
+func expandHostVars(remote, cmd string) (string, error) {
+	if !hostPlaceholder.MatchString(cmd) {
+		return cmd, nil
+	}
+	inv := loadInventory()
+	if inv == nil {
+		return "", fmt.Errorf("{host.<name>} in the command needs an -inventory")
+	}
+	host, ok := inv.findHost(remote)
+	if !ok {
+		return "", fmt.Errorf("%s is not a host in %s", remote, inv.name)
+	}
+	explainf("filling in the variables of %s from %s", host, inv.name)
+	return inv.expandHostVars(host, cmd)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testInventory = `# fleet
+bastion ansible_host=203.0.113.1
+
+[web]
+web1 ansible_host=10.0.0.1 ansible_user=deploy
+web2
+
+[db]
+db1 ansible_host="fe80::1"
+web2
+
+[prod:children]
+web
+db
+
+[prod:vars]
+ansible_user=ops
+ansible_port=22
+
+[web:vars]
+ansible_port='2200'
+
+[db:vars]
+ansible_port=5022
+
+[all:vars]
+ansible_user=admin
+region=eu-north-1
+`
+
+func TestReadInventory(t *testing.T) {
+	inv, err := readInventory(writeTemp(t, testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := []struct {
+		group string
+		want  []string
+	}{
+		{"all", []string{"bastion", "web1", "web2", "db1"}},
+		{"ungrouped", []string{"bastion"}},
+		{"web", []string{"web1", "web2"}},
+		{"prod", []string{"web1", "web2", "db1"}},
+		{"missing", nil},
+	}
+	for _, tt := range members {
+		if got := inv.members(tt.group); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("members(%q) = %q, want %q", tt.group, got, tt.want)
+		}
+	}
+
+	remotes := []struct {
+		host string
+		want string
+	}{
+		// host variables win over group variables
+		{"web1", "deploy@10.0.0.1#2200"},
+		// child groups win over parents, and of web and db at the
+		// same depth the later name in alphabetical order
+		{"web2", "ops@web2#2200"},
+		{"db1", "ops@[fe80::1]#5022"},
+		// all is the last resort
+		{"bastion", "admin@203.0.113.1"},
+	}
+	for _, tt := range remotes {
+		for i := 0; i < 10; i++ {
+			if got := inv.remote(tt.host); got != tt.want {
+				t.Errorf("remote(%q) = %q, want %q", tt.host, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestExpandHostVars(t *testing.T) {
+	inv, err := readInventory(writeTemp(t, testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []struct {
+		remote string
+		want   string
+		ok     bool
+	}{
+		{"deploy@10.0.0.1#2200", "web1", true},
+		{"ops@[fe80::1]#5022:/srv", "db1", true},
+		{"admin@203.0.113.1", "bastion", true},
+		{"deploy@10.0.0.1", "", false},
+		{"elsewhere", "", false},
+	}
+	for _, tt := range hosts {
+		if got, ok := inv.findHost(tt.remote); got != tt.want || ok != tt.ok {
+			t.Errorf("findHost(%q) = %q, %v, want %q, %v", tt.remote, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	tests := []struct {
+		host, cmd string
+		want      string
+	}{
+		{"web1", "./deploy --region {host.region}", "./deploy --region 'eu-north-1'"},
+		{"web1", "echo {host.ansible_user}@{host.ansible_port}", "echo 'deploy'@'2200'"},
+		{"db1", "find . -exec echo {} ';' {host}", "find . -exec echo {} ';' {host}"},
+	}
+	for _, tt := range tests {
+		got, err := inv.expandHostVars(tt.host, tt.cmd)
+		if err != nil || got != tt.want {
+			t.Errorf("expandHostVars(%q, %q) = %q, %v, want %q", tt.host, tt.cmd, got, err, tt.want)
+		}
+	}
+	if _, err := inv.expandHostVars("web1", "./deploy {host.missing}"); err == nil {
+		t.Error("expandHostVars accepted a missing variable")
+	}
+}
+
+func TestReadInventoryErrors(t *testing.T) {
+	if _, err := readInventory(writeTemp(t, "[web\nweb1\n")); err == nil {
+		t.Error("readInventory accepted a malformed section")
+	}
+}
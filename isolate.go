@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+var readOnly = flag.Bool("read-only", false,
+	"run the command in a read-only view of the remote directory")
+
+// Makes the working directory read-only for the command by bind
+// mounting it onto itself in a private user and mount namespace.  The
+// directory has to be entered again for the new mount to take effect.
+const readOnlyPrefix = `unshare --user --map-root-user --mount sh -c ` +
+	`'mount --bind "$PWD" "$PWD" && mount -o remount,bind,ro "$PWD" && cd "$PWD" && exec "$@"' - `
+
+var overlay = flag.String("overlay", "",
+	"run on a copy-on-write overlay of the remote directory, then `discard`, keep, or apply the changes")
+
+// Parent of the overlay upper layers on the remote.
+const overlayRoot = cacheRoot + "/overlay"
+
+// Directory holding the upper layer of the overlay for this run.
+var overlayDir string
+
+// Mounts an overlay on the working directory in a private user and
+// mount namespace, so that all changes go to the upper layer.
+func makeOverlayPrefix(dir string) string {
+	mnt := fmt.Sprintf(`mount -t overlay overlay -o lowerdir="$PWD",upperdir=%s/upper,workdir=%s/work "$PWD"`, dir, dir)
+	return `unshare --user --map-root-user --mount sh -c '` + mnt + ` && cd "$PWD" && exec "$@"' - `
+}
+
+// Creates the directory for the upper layer on login.
+func makeOverlayDir(login string) (string, error) {
+	switch *overlay {
+	case "discard", "keep", "apply":
+	default:
+		exit(EX_USAGE, "-overlay must be discard, keep, or apply")
+	}
+	enforceQuota(login)
+	mk := fmt.Sprintf(`mkdir -p %s && d=$(mktemp -d %s/XXXXXXXX) && mkdir "$d/upper" "$d/work" && %s && echo "$d"`,
+		overlayRoot, overlayRoot, makeLockCmd)
+	out, err := remoteOutput(login, mk)
+	if err != nil {
+		return "", fmt.Errorf("creating overlay on %s: %v", login, err)
+	}
+	dir := strings.TrimSpace(string(out))
+	lockedDirs = append(lockedDirs, dir)
+	return dir, nil
+}
+
+// Discards, keeps, or applies the changes in the upper layer in dir
+// to the remote directory path, as asked for with -overlay.  Applying
+// removes files deleted in the overlay, which are whiteouts in the
+// upper layer, and copies everything else over.
+func finishOverlay(login string, dir string, path string) error {
+	remove := fmt.Sprintf("chmod -R u+rwx %s && rm -rf %s %s", dir, dir, lockFile(dir))
+	var cmd string
+	switch *overlay {
+	case "keep":
+		fmt.Fprintf(os.Stderr, "%s: changes kept in %s\n", os.Args[0], rcpu.HostPath(login, dir+"/upper"))
+		return nil
+	case "apply":
+		cmd = fmt.Sprintf(`cd %s/upper && find . -type c -exec sh -c 'rm -rf "$0/$1"' %s {} \; && `+
+			`find . -type c -delete && cp -a . %s/ && %s`, dir, path, path, remove)
+	default:
+		cmd = remove
+	}
+	if _, err := remoteOutput(login, cmd); err != nil {
+		return fmt.Errorf("%s overlay %s: %v", *overlay, dir, err)
+	}
+	return nil
+}
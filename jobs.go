@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"sny.no/cpu/rcpu"
+)
+
+var (
+	at       = flag.String("at", "", "defer the command until `time`, such as 02:00, and return at once")
+	whenIdle = flag.Bool("when-idle", false,
+		"defer the command until the remote's load average is below half its processors, and return at once")
+)
+
+// Parent of the directories of deferred jobs on the remote.  Each holds
+// the command, its state, its process ID, and its output.
+const jobsRoot = cacheRoot + "/jobs"
+
+// Returns how long to wait for the next occurrence of the local time
+// t, given as HH:MM or in RFC 3339 format.
+func untilTime(t string, now time.Time) (time.Duration, error) {
+	if when, err := time.Parse(time.RFC3339, t); err == nil {
+		return when.Sub(now), nil
+	}
+	clock, err := time.ParseInLocation("15:04", t, now.Location())
+	if err != nil {
+		return 0, fmt.Errorf("invalid -at %q: want HH:MM or RFC 3339", t)
+	}
+	when := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !when.After(now) {
+		when = when.AddDate(0, 0, 1)
+	}
+	return when.Sub(now), nil
+}
+
+// Hands args to login to be run under path once the -at time has come
+// and the remote is idle, if -when-idle is given.  The job is detached
+// from the session, so cpu returns as soon as it is queued.
+func deferCommand(login string, path string, args []string) int {
+	var wait string
+	if *at != "" {
+		d, err := untilTime(*at, time.Now())
+		if err != nil {
+			exit(EX_USAGE, "%v", err)
+		}
+		explainf("waiting %v on %s before running the command", d.Round(time.Second), login)
+		wait += fmt.Sprintf("sleep %d; ", int64(d.Seconds()))
+	}
+	if *whenIdle {
+		explainf("waiting for the load average on %s to drop below half its processors", login)
+		wait += `while [ $(awk -v n=$(nproc) '{ print ($1 < n / 2) }' /proc/loadavg) = 0 ]; do sleep 60; done; `
+	}
+
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	job := fmt.Sprintf(`%s%secho running > "$d/state"; %s; echo "exited $?" > "$d/state"`,
+		makeLockDirsCmd(), wait, makeRemoteCmd(rcpu.MapPath(path), args))
+	queue := fmt.Sprintf(`d=%s/$(date +%%Y%%m%%d-%%H%%M%%S)-$$; mkdir -p "$d" && cd "$d" && `+
+		`printf '%%s\n' %s > command && echo waiting > state || exit 1; `+
+		`d=$d nohup setsid sh -c %s > output 2>&1 < /dev/null & echo $! > pid; echo "${d##*/}"`,
+		jobsRoot, rcpu.Quote(strings.Join(args, " ")), rcpu.Quote(job))
+	out, err := remoteOutput(login, queue)
+	if err != nil {
+		exit(EX_NOHOST, "%s: %v", login, err)
+	}
+	fmt.Fprintf(os.Stderr, "%s: queued job %s on %s\n", os.Args[0], strings.TrimSpace(string(out)), colorHost(login, os.Stderr))
+	return 0
+}
+
+// jobs lists the deferred jobs on the remote, or shows the output of
+// the jobs given.
+func jobs(login string, path string, args []string) int {
+	if len(args) > 0 {
+		for _, id := range args {
+			out, err := remoteOutput(login, fmt.Sprintf("cat %s/%s/output", jobsRoot, rcpu.Quote(id)))
+			if err != nil {
+				exit(EX_DATAERR, "%s: no such job %s", login, id)
+			}
+			os.Stdout.Write(out)
+		}
+		return 0
+	}
+
+	list := fmt.Sprintf(`cd %s 2>/dev/null || exit 0; for d in *; do `+
+		`s=$(cat "$d/state"); case $s in exited*) ;; *) [ -d /proc/$(cat "$d/pid") ] || s=lost;; esac; `+
+		`printf '%%s\t%%s\t%%s\n' "$d" "$s" "$(cat "$d/command")"; done`, jobsRoot)
+	out, err := remoteOutput(login, list)
+	if err != nil {
+		exit(EX_NOHOST, "%s: %v", login, err)
+	}
+	fmt.Print(string(out))
+	return 0
+}
+
+// logs shows the output of a deferred job on the remote, with -f as it
+// is written until the job ends.  The output is only watched, so
+// several terminals may follow the same job.
+func logs(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("f", false, "keep showing new output until the job ends")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s logs [-f] job\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	show := "cat output"
+	if *follow {
+		show = `tail -n +1 -f --pid="$(cat pid)" output`
+	}
+	cmd := fmt.Sprintf(`cd %s/%s 2>/dev/null || { echo "no such job %s" >&2; exit 1; }; %s`,
+		jobsRoot, rcpu.Quote(fs.Arg(0)), fs.Arg(0), show)
+	sshArgs := append(makeSshOptions(), "-n", "-T", login, cmd)
+	return runCommand(exec.Command("ssh", sshArgs...))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Terminal colours of journal entries by syslog priority.
+var priorityColors = map[string]string{
+	"0": "\x1b[1;31m", "1": "\x1b[1;31m", "2": "\x1b[1;31m", "3": "\x1b[31m",
+	"4": "\x1b[33m",
+	"5": "\x1b[1m",
+	"7": "\x1b[2m",
+}
+
+// journal shows the system log of the remote, from journald or else
+// from the syslog files, with timestamps in the local time zone.
+func journal(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("journal", flag.ExitOnError)
+	follow := fs.Bool("f", false, "keep showing new entries as they are logged")
+	lines := fs.Int("n", 50, "show the last `count` entries")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s journal [-f] [-n count] [unit]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	journalctl := fmt.Sprintf("journalctl -o json -n %d", *lines)
+	tail := fmt.Sprintf("tail -n %d", *lines)
+	if *follow {
+		journalctl += " -f"
+		tail += " -F"
+	}
+	filter := ""
+	if fs.NArg() == 1 {
+		journalctl += " -u " + rcpu.Quote(fs.Arg(0))
+		filter = " | grep --line-buffered -F " + rcpu.Quote(fs.Arg(0))
+	}
+	// syslog lines are marked so they are not taken for JSON
+	show := fmt.Sprintf(`if command -v journalctl >/dev/null; then %s; else `+
+		`%s $(ls /var/log/syslog /var/log/messages 2>/dev/null | head -n 1)%s | sed -u 's/^/-/'; fi`,
+		journalctl, tail, filter)
+
+	cmd := exec.Command("ssh", append(makeSshOptions(), "-T", login, show)...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	explainf("executing %s", cmd)
+	bugReport.exec(cmd.Args)
+	if err := cmd.Start(); err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	color := isatty(os.Stdout) && os.Getenv("NO_COLOR") == ""
+	printJournal(stdout, os.Stdout, color)
+	if err := cmd.Wait(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode()
+		}
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	return 0
+}
+
+// Formats the JSON entries of journalctl(1) and the marked syslog
+// lines read from r.
+func printJournal(r io.Reader, w io.Writer, color bool) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "-") {
+			fmt.Fprintln(w, line[1:])
+			continue
+		}
+		var entry map[string]interface{}
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			fmt.Fprintln(w, line)
+			continue
+		}
+		field := func(name string) string {
+			s, _ := entry[name].(string)
+			return s
+		}
+
+		when := field("__REALTIME_TIMESTAMP")
+		if us, err := strconv.ParseInt(when, 10, 64); err == nil {
+			when = time.UnixMicro(us).Local().Format("Jan 02 15:04:05")
+		}
+		ident := field("SYSLOG_IDENTIFIER")
+		if ident == "" {
+			ident = field("_COMM")
+		}
+		if pid := field("_PID"); pid != "" {
+			ident += "[" + pid + "]"
+		}
+		msg := field("MESSAGE")
+		if raw, ok := entry["MESSAGE"].([]interface{}); ok {
+			// messages that are not valid UTF-8 come as arrays of bytes
+			b := make([]byte, 0, len(raw))
+			for _, c := range raw {
+				if n, ok := c.(float64); ok {
+					b = append(b, byte(n))
+				}
+			}
+			msg = strconv.QuoteToGraphic(string(b))
+		}
+
+		text := fmt.Sprintf("%s %s %s: %s", when, field("_HOSTNAME"), ident, msg)
+		if c, ok := priorityColors[field("PRIORITY")]; ok && color {
+			text = c + text + "\x1b[0m"
+		}
+		fmt.Fprintln(w, text)
+	}
+}
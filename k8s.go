@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Prefix of remotes naming a Kubernetes pod rather than a machine
+// reached over ssh(1): k8s:<namespace>/<pod>[/<container>][:<path>].
+const k8sPrefix = "k8s:"
+
+// A pod to execute commands in with kubectl(1).
+type pod struct {
+	namespace string
+	name      string
+	container string // default container of the pod if empty
+}
+
+// k8s:<namespace>/<pod>[/<container>][:<path>] -> pod, path
+func splitPod(remote string) (pod, string) {
+	spec := strings.TrimPrefix(remote, k8sPrefix)
+	var path string
+	if i := strings.Index(spec, ":"); i >= 0 {
+		spec, path = spec[:i], spec[i+1:]
+		explainf("remote %q overrides the working directory with %s", remote, path)
+	} else {
+		cwd, _ := os.Getwd()
+		path = rcpu.MapPath(cwd)
+		explainf("remote %q has no path, so mapping the working directory %s", remote, path)
+	}
+
+	ss := strings.Split(spec, "/")
+	if len(ss) < 2 || len(ss) > 3 || ss[0] == "" || ss[1] == "" {
+		exit(EX_USAGE, "malformed pod in remote %q, want k8s:namespace/pod[/container]", remote)
+	}
+	p := pod{namespace: ss[0], name: ss[1]}
+	if len(ss) == 3 {
+		p.container = ss[2]
+	}
+	return p, path
+}
+
+// Runs args under path in the pod and returns its exit status.  The
+// command is given to sh(1), as many images have no bash, and nothing
+// is set up around it, as the home directory and /tmp that commands
+// sent over ssh(1) write to are seldom there.
+func runPod(p pod, path string, args []string) int {
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+
+	kubectlArgs := []string{"exec", "-i", "-n", p.namespace, p.name}
+	if isatty(os.Stdin) && isatty(os.Stdout) {
+		kubectlArgs = append(kubectlArgs, "-t")
+	}
+	if p.container != "" {
+		kubectlArgs = append(kubectlArgs, "-c", p.container)
+	}
+	cmd := fmt.Sprintf("cd %s && %s", quoteDir(path), strings.Join(args, " "))
+	kubectlArgs = append(kubectlArgs, "--", "sh", "-c", cmd)
+	return runCommand(exec.Command("kubectl", kubectlArgs...))
+}
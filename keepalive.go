@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	keepAlive = flag.Duration("keepalive", envDuration("CPU_KEEPALIVE", 30*time.Second),
+		"probe an idle connection at this `interval` so that NAT gateways keep it open, or 0 not to")
+	keepAliveCount = flag.Int("keepalive-count", 4,
+		"give up on the connection after this many unanswered probes")
+)
+
+// Options for ssh(1) keeping idle connections alive, if any.
+var keepAliveOptions []string
+
+// Returns the duration in the environment variable name, or def.
+func envDuration(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return d
+	}
+	return def
+}
+
+// Decides whether to keep the connection to login alive.  Remotes
+// that have ServerAliveInterval set in ssh_config(5) keep their own
+// setting unless -keepalive or CPU_KEEPALIVE is given.
+func setupKeepAlive(login string) {
+	explicit := os.Getenv("CPU_KEEPALIVE") != ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "keepalive" || f.Name == "keepalive-count" {
+			explicit = true
+		}
+	})
+	if !explicit {
+		if conf := effectiveConfig(login); conf == nil || conf["serveraliveinterval"] != "0" {
+			explainf("leaving keep-alives to the ServerAliveInterval in ssh_config")
+			return
+		}
+	}
+	secs := int(keepAlive.Seconds())
+	explainf("probing the connection after %ds of silence, giving up after %d probes", secs, *keepAliveCount)
+	keepAliveOptions = []string{
+		"-o", "ServerAliveInterval=" + strconv.Itoa(secs),
+		"-o", fmt.Sprintf("ServerAliveCountMax=%d", *keepAliveCount),
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+
+	"sny.no/cpu/rcpu"
+)
+
+var local = flag.Bool("local", false, "run the command here instead of on the remote, however it is chosen")
+
+// Runs args in the working directory of this machine as cpu would have
+// run them on the remote, with the same expansion and -env-file, and
+// returns its exit status.
+func runLocal(args []string) int {
+	if len(args) == 0 {
+		exit(EX_USAGE, "missing command")
+	}
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	explainf("-local runs the command here rather than on a remote")
+
+	cmd := exec.Command(args[0], args[1:]...)
+	if sh, err := exec.LookPath("sh"); err == nil {
+		cmd = exec.Command(sh, "-c", rcpu.Join(args, expansion(), os.Getenv))
+	}
+	cmd.Env = os.Environ()
+	if *envFile != "" {
+		vars, err := readEnvFile(*envFile)
+		if err != nil {
+			exit(EX_DATAERR, "%v", err)
+		}
+		cmd.Env = append(cmd.Env, vars...)
+	}
+	return runCommand(cmd)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verbosity is the -v flag, which can be given more than once, or as
+// -vv and -vvv.
+type verbosity int
+
+func (v *verbosity) String() string   { return strconv.Itoa(int(*v)) }
+func (v *verbosity) IsBoolFlag() bool { return true }
+
+func (v *verbosity) Set(s string) error {
+	switch s {
+	case "true":
+		*v++
+	case "false":
+		*v = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = verbosity(n)
+	}
+	return nil
+}
+
+// Levels of -v.  At the first, cpu logs the programs it runs; at the
+// second, every step -explain would describe; and at the third, how
+// long each stage took and ssh(1)'s own debug output.
+const (
+	logCommands = 1
+	logSteps    = 2
+	logTimings  = 3
+)
+
+var (
+	verbose   verbosity
+	logFormat = flag.String("log-format", os.Getenv("CPU_LOG_FORMAT"),
+		"write the log as `text` or as json, one object per line")
+
+	// When cpu started, for the timings logged at -vvv.
+	startTime = time.Now()
+)
+
+func init() {
+	flag.Var(&verbose, "v", "increase verbosity; may be repeated")
+	flag.BoolFunc("vv", "log every step taken, as -v -v", func(string) error {
+		verbose = logSteps
+		return nil
+	})
+	flag.BoolFunc("vvv", "also log timings and ssh debug output, as -v -v -v", func(string) error {
+		verbose = logTimings
+		return nil
+	})
+}
+
+// Sets up the log according to -log-format.
+func setupLogging() {
+	switch *logFormat {
+	case "", "text":
+	case "json":
+		log.SetFlags(0)
+		log.SetOutput(jsonLog{})
+	default:
+		exit(EX_USAGE, "-log-format must be text or json")
+	}
+}
+
+// jsonLog is the log's output when -log-format is json.  The log
+// package writes each entry in one call.
+type jsonLog struct{}
+
+func (jsonLog) Write(p []byte) (int, error) {
+	return len(p), writeJSON("", strings.TrimSuffix(string(p), "\n"))
+}
+
+func writeJSON(kind string, msg string) error {
+	entry := struct {
+		Time string `json:"time"`
+		Kind string `json:"kind,omitempty"`
+		Msg  string `json:"msg"`
+	}{time.Now().Format(time.RFC3339Nano), kind, msg}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stderr.Write(append(b, '\n'))
+	return err
+}
+
+// Logs an entry of the given kind, such as "step" or "timing", if -v
+// is given at least level times.
+func debugf(level int, kind string, format string, a ...interface{}) {
+	if int(verbose) < level {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	if *logFormat == "json" {
+		writeJSON(kind, msg)
+	} else {
+		log.Printf("%s: %s", kind, msg)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	mount = flag.Bool("mount", false,
+		"export the working directory to the remote over SFTP and run the command on it")
+	mountCache = flag.Duration("mount-cache", 5*time.Second,
+		"how long the remote may cache file metadata of a -mount; 0 disables caching")
+)
+
+// Read-ahead in bytes for sequential reads of a -mount.
+const mountReadahead = 1 << 20
+
+// Locations of sftp-server(8) on common systems.
+var sftpServers = []string{
+	"/usr/lib/openssh/sftp-server",
+	"/usr/libexec/openssh/sftp-server",
+	"/usr/libexec/sftp-server",
+	"/usr/lib/ssh/sftp-server",
+}
+
+// Parent of all mount points on the remote.
+const mountRoot = cacheRoot + "/mnt"
+
+// An export serves a local directory to the remote.  A local
+// sftp-server(8) is connected across an ssh(1) session to sshfs(1)
+// running in passive mode on the remote, so no connection from the
+// remote back to the local system is needed.
+type export struct {
+	login string
+	dir   string // mount point on the remote
+	sftp  *exec.Cmd
+	sshfs *exec.Cmd
+}
+
+// Mounts the local directory src on login and returns the export.
+func mountExport(login string, src string) (*export, error) {
+	server, err := findSftpServer()
+	if err != nil {
+		return nil, err
+	}
+
+	mk := fmt.Sprintf("mkdir -p %s && mktemp -d %s/XXXXXXXX", mountRoot, mountRoot)
+	out, err := remoteOutput(login, mk)
+	if err != nil {
+		return nil, fmt.Errorf("creating mount point on %s: %v", login, err)
+	}
+	e := &export{login: login, dir: strings.TrimSpace(string(out))}
+
+	opts := strings.Join(makeSshfsOptions(), ",")
+	sshfs := fmt.Sprintf("sshfs -o %s :%s %s", opts, src, e.dir)
+	e.sftp = exec.Command(server)
+	e.sshfs = exec.Command("ssh", append(makeSshOptions(), "-T", login, sshfs)...)
+	e.sshfs.Stderr = os.Stderr
+
+	// cross-connect the standard streams of the two
+	if e.sftp.Stdin, err = e.sshfs.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if e.sshfs.Stdin, err = e.sftp.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if verbose >= logCommands {
+		log.Println(e.sftp, "<->", e.sshfs)
+	}
+	bugReport.exec(e.sshfs.Args)
+	if err := e.sftp.Start(); err != nil {
+		return nil, err
+	}
+	if err := e.sshfs.Start(); err != nil {
+		e.sftp.Process.Kill()
+		return nil, err
+	}
+
+	wait := fmt.Sprintf("for i in $(seq 100); do mountpoint -q %s && exit 0; sleep 0.1; done; exit 1", e.dir)
+	if _, err := remoteOutput(login, wait); err != nil {
+		e.unmount()
+		return nil, fmt.Errorf("%s:%s did not get mounted", login, e.dir)
+	}
+	return e, nil
+}
+
+// Options given to sshfs(1) on the remote.  Compilers stat the same
+// headers over and over and then read them from start to end, so
+// metadata is cached for a while and reads are expanded to read ahead.
+// The page cache is kept as long as a file's modification time stays
+// the same, so local writes are seen the next time it is opened.
+func makeSshfsOptions() []string {
+	opts := []string{"passive", fmt.Sprintf("max_readahead=%d", mountReadahead)}
+	secs := int(mountCache.Seconds())
+	if secs <= 0 {
+		return append(opts, "dir_cache=no", "attr_timeout=0", "entry_timeout=0")
+	}
+	return append(opts,
+		"auto_cache",
+		"dir_cache=yes",
+		fmt.Sprintf("dcache_timeout=%d", secs),
+		fmt.Sprintf("attr_timeout=%d", secs),
+		fmt.Sprintf("entry_timeout=%d", secs))
+}
+
+// Unmounts the export and waits for both ends to terminate.
+func (e *export) unmount() error {
+	_, err := remoteOutput(e.login, fmt.Sprintf("fusermount -u %s; rmdir %s", e.dir, e.dir))
+	e.sshfs.Wait()
+	e.sftp.Wait()
+	return err
+}
+
+func findSftpServer() (string, error) {
+	for _, p := range sftpServers {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+	}
+	if p, err := exec.LookPath("sftp-server"); err == nil {
+		return p, nil
+	}
+	return "", fmt.Errorf("sftp-server not found")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	notifySinks stringList
+	notifyAfter = flag.Duration("notify-after", envDuration("CPU_NOTIFY_AFTER", 0),
+		"only notify about runs taking longer than this `duration`, failed or tagged ones")
+	tags stringList
+)
+
+func init() {
+	if s := os.Getenv("CPU_NOTIFY"); s != "" {
+		notifySinks = strings.Split(s, ",")
+	}
+	flag.Var(&notifySinks, "notify",
+		"notify `sink` when the command finishes: a webhook URL, slack:URL, or mailto:address (repeatable)")
+	flag.Var(&tags, "tag", "tag the run, which is always notified about (repeatable)")
+}
+
+// Number of lines of output included in notifications.
+const excerptLines = 20
+
+// tailBuffer keeps the last lines written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	part  string
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := strings.Split(t.part+string(p), "\n")
+	t.part = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		t.lines = append(t.lines, strings.TrimRight(line, "\r"))
+	}
+	if len(t.lines) > excerptLines {
+		t.lines = t.lines[len(t.lines)-excerptLines:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(append(t.lines, t.part), "\n")
+}
+
+// Last lines of the command's output, kept if notifications are enabled.
+var outputTail *tailBuffer
+
+// Sends a notification about the run of command on login to each
+// sink, if the run took longer than -notify-after, failed, or was
+// tagged.
+func notify(login string, command []string, code int, took time.Duration) {
+	if len(notifySinks) == 0 || code == 0 && took < *notifyAfter && len(tags) == 0 {
+		return
+	}
+	status := "finished"
+	if code != 0 {
+		status = fmt.Sprintf("failed with status %d", code)
+	}
+	sendNotification(login, command, code, took, status)
+}
+
+// Sends a notification that command on login has the given status to
+// each sink.  The code is -1 while the command is still running.
+func sendNotification(login string, command []string, code int, took time.Duration, status string) {
+	subject := fmt.Sprintf("cpu: %s on %s %s after %v", strings.Join(command, " "), login, status, took.Round(time.Second))
+	if len(tags) > 0 {
+		subject += " [" + strings.Join(tags, ", ") + "]"
+	}
+	excerpt := ""
+	if outputTail != nil {
+		excerpt = outputTail.String()
+	}
+
+	for _, sink := range notifySinks {
+		var err error
+		switch {
+		case strings.HasPrefix(sink, "slack:"):
+			text := subject
+			if excerpt != "" {
+				text += "\n```" + excerpt + "```"
+			}
+			err = postJSON(strings.TrimPrefix(sink, "slack:"), map[string]string{"text": text})
+		case strings.HasPrefix(sink, "mailto:"):
+			err = sendMail(strings.TrimPrefix(sink, "mailto:"), subject, excerpt)
+		default:
+			err = postJSON(sink, map[string]interface{}{
+				"host":     login,
+				"command":  strings.Join(command, " "),
+				"status":   code,
+				"duration": took.Seconds(),
+				"tags":     append([]string{}, tags...),
+				"output":   excerpt,
+			})
+		}
+		if err != nil {
+			log.Printf("notify %s: %v", sink, err)
+		}
+	}
+}
+
+func postJSON(url string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+func sendMail(to string, subject string, body string) error {
+	cmd := exec.Command("sendmail", "-t")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", to, subject, body))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	outputFile = flag.String("o", "", "also write the command's output to `file`, including stderr unless -e is given")
+	errorFile  = flag.String("e", "", "also write the command's stderr to `file`")
+)
+
+// syncWriter serialises writes to w, which stdout and stderr may share.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(p)
+}
+
+// Returns writers copying stdout and stderr to the files of -o and -e
+// as well, and a function closing the files.
+func teeOutput(stdout io.Writer, stderr io.Writer) (io.Writer, io.Writer, func()) {
+	var files []*os.File
+	open := func(name string) io.Writer {
+		f, err := os.Create(name)
+		if err != nil {
+			exit(EX_DATAERR, "%v", err)
+		}
+		files = append(files, f)
+		return &syncWriter{w: f}
+	}
+	if *outputFile != "" {
+		out := open(*outputFile)
+		stdout = io.MultiWriter(stdout, out)
+		if *errorFile == "" {
+			stderr = io.MultiWriter(stderr, out)
+		}
+	}
+	if *errorFile != "" {
+		stderr = io.MultiWriter(stderr, open(*errorFile))
+	}
+	return stdout, stderr, func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Subcommands that take their remotes as arguments instead of from -r.
+var fleetSubcommands = map[string]func(args []string) int{
+	"direnv": direnv,
+	"doctor": doctor,
+	"hook":   hook,
+	"panes":  panes,
+	"ping":   ping,
+}
+
+// Returns the flags given to this cpu on the command line, other than
+// -r, for passing on to the cpu run for each remote.
+func passedFlags() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "r" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// Shown in the status line of a panes window, saying where input goes.
+const broadcastIndicator = "#{?synchronize-panes,#[reverse] input to all panes #[default],input to #{pane_title} only}"
+
+// panes opens a tmux(1) window split into one pane per remote, each
+// with an interactive shell in the mapped directory.  Keystrokes go
+// either to the focused pane or to all of them, toggled with a key
+// after the tmux prefix.
+func panes(args []string) int {
+	fs := flag.NewFlagSet("panes", flag.ExitOnError)
+	broadcast := fs.Bool("broadcast", false, "start out sending keystrokes to all panes")
+	toggle := fs.String("toggle-key", "B", "`key` after the tmux prefix switching between sending keystrokes to all panes and one")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s panes [-broadcast] [-toggle-key key] remote ...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EX_USAGE
+	}
+	if _, err := exec.LookPath("tmux"); err != nil {
+		exit(EX_CMDNFOUND, "panes: tmux is needed for split views")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	cwd, _ := os.Getwd()
+	session := fmt.Sprintf("cpu-%d", os.Getpid())
+	for i, r := range fs.Args() {
+		cpuArgs := append([]string{self}, passedFlags()...)
+		cpuArgs = append(cpuArgs, "-r", r, `exec "$SHELL" -l`)
+		shellCmd := quoteArgs(cpuArgs)
+		var tmux []string
+		if i == 0 {
+			tmux = []string{"new-session", "-d", "-s", session, "-c", cwd, shellCmd}
+		} else {
+			tmux = []string{"split-window", "-t", session, "-c", cwd, shellCmd}
+		}
+		if err := tmuxRun(tmux...); err != nil {
+			exit(EX_CMDNFOUND, "panes: %v", err)
+		}
+		tmuxRun("select-pane", "-t", session, "-T", r)
+		login := r
+		if rr, err := rcpu.ParseRemote(r); err == nil {
+			login = rr.Login
+		}
+		tmuxRun("set-option", "-p", "-t", session, "pane-border-format",
+			fmt.Sprintf(" #[fg=colour%d]#{pane_title}#[default] ", hostColorNumber(login)))
+		// keep the panes from becoming too small to split
+		tmuxRun("select-layout", "-t", session, "tiled")
+	}
+	tmuxRun("set-option", "-t", session, "pane-border-status", "top")
+	tmuxRun("set-option", "-t", session, "status-right", broadcastIndicator)
+	tmuxRun("set-option", "-t", session, "status-right-length", "60")
+	// key bindings are shared by all sessions, so the toggle leaves
+	// others alone
+	tmuxRun("bind-key", *toggle, "if-shell", "-F", "#{m:cpu-*,#{session_name}}",
+		"set-window-option synchronize-panes")
+	if *broadcast {
+		tmuxRun("set-window-option", "-t", session, "synchronize-panes", "on")
+	}
+
+	attach := "attach-session"
+	if os.Getenv("TMUX") != "" {
+		attach = "switch-client"
+	}
+	cmd := exec.Command("tmux", attach, "-t", session)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		exit(EX_CMDNFOUND, "panes: %v", err)
+	}
+	return 0
+}
+
+func tmuxRun(args ...string) error {
+	cmd := exec.Command("tmux", args...)
+	explainf("executing %s", cmd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux %s: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
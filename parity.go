@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// parityFact is something about the environment commands run in,
+// found by a shell command whose output should be the same here and on
+// the remote.
+type parityFact struct {
+	name string
+	cmd  string
+}
+
+// Facts compared by the parity subcommand, along with those of the
+// parity file.
+var defaultParityFacts = []parityFact{
+	{"cc", "cc --version | head -n 1"},
+	{"clang", "clang --version | head -n 1"},
+	{"gcc", "gcc --version | head -n 1"},
+	{"make", "make --version | head -n 1"},
+	{"cmake", "cmake --version | head -n 1"},
+	{"go", "go version"},
+	{"rustc", "rustc --version"},
+	{"python3", "python3 --version"},
+	{"node", "node --version"},
+	{"java", "java -version 2>&1 | head -n 1"},
+	{"env", "env | grep -E '^(CC|CXX|CFLAGS|CXXFLAGS|LDFLAGS|GOFLAGS|RUSTFLAGS|PYTHONPATH|JAVA_HOME)=' | sort"},
+	{"locale", `echo "LANG=$LANG LC_ALL=$LC_ALL LC_CTYPE=$LC_CTYPE"`},
+	{"tz", "date +%Z"},
+	{"umask", "umask"},
+	{"ulimit-n", "ulimit -n"},
+	{"ulimit-s", "ulimit -s"},
+	{"git-head", "git rev-parse HEAD"},
+	{"git-branch", "git rev-parse --abbrev-ref HEAD"},
+	{"git-dirty", "git status --porcelain | wc -l | tr -d ' '"},
+}
+
+// File of facts to compare besides the defaults, one per line:
+//
+//	name command
+//
+// where command is shell text printing the fact.  A fact named as one
+// of the defaults replaces it.
+func parityFile() string {
+	return configFile("parity")
+}
+
+func readParityFacts(name string) ([]parityFact, error) {
+	facts := append([]parityFact(nil), defaultParityFacts...)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return facts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+next:
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fact, cmd, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: missing command", name, n)
+		}
+		cmd = strings.TrimSpace(cmd)
+		for i := range facts {
+			if facts[i].name == fact {
+				facts[i].cmd = cmd
+				continue next
+			}
+		}
+		facts = append(facts, parityFact{fact, cmd})
+	}
+	return facts, s.Err()
+}
+
+// Separates the facts in the output of the script finding them.
+const factSeparator = "\x1e"
+
+// Returns shell text printing each fact after a separator, without
+// the errors of commands that are missing.
+func makeParityScript(facts []parityFact) string {
+	var b strings.Builder
+	for _, f := range facts {
+		fmt.Fprintf(&b, "printf '%s'; { %s; } 2>/dev/null; ", factSeparator, f.cmd)
+	}
+	return b.String()
+}
+
+// Splits the output of the script into the value of each fact, with
+// whatever the shell printed before the first left out.
+func splitFacts(out string, n int) []string {
+	values := make([]string, n)
+	parts := strings.Split(out, factSeparator)
+	for i := range values {
+		if i+1 < len(parts) {
+			values[i] = strings.TrimSpace(strings.ReplaceAll(parts[i+1], "\r", ""))
+		}
+	}
+	return values
+}
+
+// parity compares facts about the environment commands run in here
+// and on the remote, such as tool versions, variables, the locale,
+// limits and the state of the git checkout, and shows those that
+// differ in the manner of a diff.  It exits 1 when any do.
+func parity(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("parity", flag.ExitOnError)
+	all := fs.Bool("all", false, "show the facts that are the same too")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s parity [-all]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return EX_USAGE
+	}
+	facts, err := readParityFacts(parityFile())
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	script := makeParityScript(facts)
+
+	local, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		exit(EX_CMDNFOUND, "parity: %v", err)
+	}
+	remote, err := remoteOutput(login, makeRemoteCmd(rcpu.MapPath(path), []string{script}))
+	if err != nil {
+		exit(EX_NOHOST, "parity: %v", err)
+	}
+	here, there := splitFacts(string(local), len(facts)), splitFacts(string(remote), len(facts))
+
+	color := os.Getenv("NO_COLOR") == "" && isatty(os.Stdout)
+	paint := func(sgr, s string) string {
+		if !color {
+			return s
+		}
+		return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+	}
+	fmt.Printf("%s\n%s\n", paint("31", "--- local"), paint("32", "+++ "+login))
+	code, differ := 0, 0
+	for i, f := range facts {
+		if here[i] == there[i] {
+			if *all {
+				fmt.Printf("  %s: %s\n", f.name, here[i])
+			}
+			continue
+		}
+		differ++
+		fmt.Println(paint("31", fmt.Sprintf("- %s: %s", f.name, here[i])))
+		fmt.Println(paint("32", fmt.Sprintf("+ %s: %s", f.name, there[i])))
+		code = 1
+	}
+	fmt.Printf("%d of %d facts differ\n", differ, len(facts))
+	return code
+}
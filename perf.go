@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// perf runs perf(1) on the remote in the mapped directory.  After
+// perf record, the recorded profile is copied back to the local
+// directory so it can be examined with local tools.
+func perf(login string, path string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s perf command [args ...]\n", os.Args[0])
+		return EX_USAGE
+	}
+	path = rcpu.MapPath(path)
+	cmd := fmt.Sprintf("cd %s && perf %s", path, strings.Join(args, " "))
+	if code := runCommand(exec.Command("ssh", append(makeSshArgs(login), cmd)...)); code != 0 || args[0] != "record" {
+		return code
+	}
+	return cp(login, path, []string{perfOutput(args[1:]), "."})
+}
+
+// Returns the file perf record writes the profile to.
+func perfOutput(args []string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return "perf.data"
+		case (arg == "-o" || arg == "--output") && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--output="):
+			return strings.TrimPrefix(arg, "--output=")
+		}
+	}
+	return "perf.data"
+}
+
+// Programs folding the stacks of perf script and drawing them, in
+// order of preference.
+var flameGraphTools = [][2]string{
+	{"inferno-collapse-perf", "inferno-flamegraph"},
+	{"stackcollapse-perf.pl", "flamegraph.pl"},
+}
+
+// flamegraph profiles a command on the remote with perf record and
+// draws a flame graph of it locally.  Paths under the remote directory
+// are translated to the local directory, so the graph refers to the
+// local sources.
+func flamegraph(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("flamegraph", flag.ExitOnError)
+	out := fs.String("o", "flamegraph.svg", "write the flame graph to `file`")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s flamegraph [-o file] command [args ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	var collapse, draw string
+	for _, tools := range flameGraphTools {
+		if _, err := exec.LookPath(tools[0]); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath(tools[1]); err == nil {
+			collapse, draw = tools[0], tools[1]
+			break
+		}
+	}
+	if collapse == "" {
+		exit(EX_CMDNFOUND, "flamegraph: neither inferno nor FlameGraph's stackcollapse-perf.pl and flamegraph.pl were found")
+	}
+
+	// a pseudo-terminal would mangle the stacks
+	compatTTY = "-T"
+	local, _ := os.Getwd()
+	path = rcpu.MapPath(path)
+	// the command's own output goes to stderr to keep stdout for perf script
+	record := fmt.Sprintf(`cd %s && pwd && t=$(mktemp) && { perf record -g -o "$t" -- %s >&2; s=$?; `+
+		`perf script -i "$t" 2>/dev/null; rm -f "$t"; exit $s; }`, path, strings.Join(fs.Args(), " "))
+	ssh := exec.Command("ssh", append(makeSshArgs(login), record)...)
+	ssh.Stdin, ssh.Stderr = os.Stdin, os.Stderr
+	stacks, err := ssh.StdoutPipe()
+	if err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	defer f.Close()
+	pr, pw := io.Pipe()
+	graph := exec.Command("sh", "-c", collapse+" | "+draw)
+	graph.Stdin, graph.Stdout, graph.Stderr = pr, f, os.Stderr
+	explainf("executing %s, drawing the stacks with %s", ssh, graph)
+	bugReport.exec(ssh.Args)
+
+	if err := ssh.Start(); err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	if err := graph.Start(); err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	translatePaths(stacks, pw, local)
+	pw.Close()
+	code := 0
+	if err := ssh.Wait(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			code = exiterr.ExitCode()
+		}
+	}
+	if err := graph.Wait(); err != nil {
+		exit(EX_DATAERR, "flamegraph: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "%s: wrote %s\n", os.Args[0], *out)
+	return code
+}
+
+// Copies perf script output from r to w, replacing the remote
+// directory, given on the first line, with the local directory.
+func translatePaths(r io.Reader, w io.Writer, local string) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	if !sc.Scan() {
+		return
+	}
+	remote := strings.TrimSpace(sc.Text())
+	for sc.Scan() {
+		line := sc.Text()
+		if remote != "" {
+			line = strings.ReplaceAll(line, remote, local)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returns how long it takes to open a TCP connection to the ssh port
+// of each remote, as shown by the picker: "-" for groups and remotes
+// reached through a proxy, whose ports cannot be tried directly, and
+// "down" for those that do not answer within a second.
+func remoteLatencies(remotes []string) []string {
+	latencies := make([]string, len(remotes))
+	var wg sync.WaitGroup
+	for i, r := range remotes {
+		wg.Add(1)
+		go func(i int, r string) {
+			defer wg.Done()
+			latencies[i] = "-"
+			if isGroup(r) {
+				return
+			}
+			conf := effectiveConfig(r)
+			if conf == nil || conf["proxyjump"] != "" && conf["proxyjump"] != "none" || conf["proxycommand"] != "" {
+				return
+			}
+			start := time.Now()
+			c, err := net.DialTimeout("tcp", net.JoinHostPort(conf["hostname"], conf["port"]), time.Second)
+			if err != nil {
+				latencies[i] = "down"
+				return
+			}
+			c.Close()
+			latencies[i] = time.Since(start).Round(time.Millisecond).String()
+		}(i, r)
+	}
+	wg.Wait()
+	return latencies
+}
+
+// Reports whether the letters of filter appear in s in order, ignoring
+// case, as in fuzzy finders.
+func fuzzyMatch(s, filter string) bool {
+	s, filter = strings.ToLower(s), strings.ToLower(filter)
+	for _, c := range filter {
+		i := strings.IndexRune(s, c)
+		if i < 0 {
+			return false
+		}
+		s = s[i+1:]
+	}
+	return true
+}
+
+// Asks which remote to use when none is given, out of the hosts and
+// groups completion offers, with fzf(1) if it is installed and by
+// number and filter otherwise.  Returns "" when the user declines or
+// cannot be asked.
+func pickRemote() string {
+	if !isatty(os.Stdin) || !isatty(os.Stderr) {
+		return ""
+	}
+	remotes := completionRemotes()
+	if len(remotes) == 0 {
+		return ""
+	}
+	latencies := remoteLatencies(remotes)
+
+	if _, err := exec.LookPath("fzf"); err == nil {
+		var lines []string
+		for i, r := range remotes {
+			lines = append(lines, r+"\t"+latencies[i])
+		}
+		fzf := exec.Command("fzf", "--prompt=remote> ", "--delimiter=\t", "--nth=1")
+		fzf.Stdin = strings.NewReader(strings.Join(lines, "\n"))
+		fzf.Stderr = os.Stderr
+		out, err := fzf.Output()
+		if err != nil {
+			return ""
+		}
+		picked, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+		return picked
+	}
+
+	tty, err := os.Open(consoleName)
+	if err != nil {
+		return ""
+	}
+	defer tty.Close()
+	in := bufio.NewReader(tty)
+	filter := ""
+	for {
+		var matches []int
+		for i, r := range remotes {
+			if fuzzyMatch(r, filter) {
+				matches = append(matches, i)
+			}
+		}
+		for n, i := range matches {
+			fmt.Fprintf(os.Stderr, "%3d  %-32s %s\n", n+1, remotes[i], latencies[i])
+		}
+		fmt.Fprint(os.Stderr, "remote number, or letters to filter by: ")
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return ""
+		}
+		line = strings.TrimSpace(line)
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(matches) {
+			return remotes[matches[n-1]]
+		} else if line == "" && len(matches) == 1 {
+			return remotes[matches[0]]
+		} else if line == "" {
+			return ""
+		}
+		filter = line
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+	"time"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Number of round trips ping times over an established session, of
+// which the fastest is shown.
+const pingRoundTrips = 5
+
+// pingResult is how long each stage of reaching a remote took, zero
+// for those that were skipped or not reached.
+type pingResult struct {
+	remote    string
+	connect   time.Duration
+	login     time.Duration
+	roundTrip time.Duration
+	err       error
+}
+
+// Times a TCP connection to the ssh port of remote, then a new ssh
+// session to it up to the point the remote shell answers, and then
+// round trips over that session.
+func pingRemote(remote string) (p pingResult) {
+	p.remote = remote
+	r, err := rcpu.ParseRemote(remote)
+	if err != nil {
+		p.err = err
+		return
+	}
+	var opts []string
+	if r.Port != "" {
+		opts = append(opts, "-o", "Port="+r.Port)
+	}
+	conf := effectiveConfig(r.Login)
+	if conf == nil {
+		p.err = fmt.Errorf("ssh cannot read its configuration for %s", r.Login)
+		return
+	}
+	if r.Port != "" {
+		conf["port"] = r.Port
+	}
+	if (conf["proxyjump"] == "" || conf["proxyjump"] == "none") && conf["proxycommand"] == "" {
+		start := time.Now()
+		c, err := net.DialTimeout("tcp", net.JoinHostPort(conf["hostname"], conf["port"]), 5*time.Second)
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.connect = time.Since(start)
+		c.Close()
+	}
+
+	// a session of its own, so that a shared connection does not hide
+	// the handshake
+	opts = append(opts, "-o", "ControlPath=none", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5")
+	args := append(append(opts, makeSshOptions()...), "-T", r.Login, `echo; while read l; do echo "$l"; done`)
+	cmd := exec.Command("ssh", args...)
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		p.err = err
+		return
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	lines := bufio.NewReader(stdout)
+	if _, err := lines.ReadString('\n'); err != nil {
+		p.err = fmt.Errorf("cannot log in")
+		return
+	}
+	p.login = time.Since(start)
+	for i := 0; i < pingRoundTrips; i++ {
+		start := time.Now()
+		io.WriteString(stdin, "ping\n")
+		if _, err := lines.ReadString('\n'); err != nil {
+			p.err = err
+			return
+		}
+		if d := time.Since(start); p.roundTrip == 0 || d < p.roundTrip {
+			p.roundTrip = d
+		}
+	}
+	return
+}
+
+func formatPingDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(100 * time.Microsecond).String()
+}
+
+// ping measures, for each remote, how long it takes to connect, to log
+// in and to get an answer over an established session, so that it is
+// clear whether slowness is down to the network, the ssh handshake, or
+// the command itself.
+func ping(args []string) int {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s ping [remote ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	remotes := checkedRemotes(fs.Args())
+	results := make(chan pingResult)
+	for _, r := range remotes {
+		go func(r string) { results <- pingRemote(r) }(r)
+	}
+	byRemote := map[string]pingResult{}
+	for range remotes {
+		p := <-results
+		byRemote[p.remote] = p
+	}
+
+	code := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REMOTE\tCONNECT\tLOGIN\tROUND TRIP\t")
+	for _, r := range remotes {
+		p := byRemote[r]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t", r, formatPingDuration(p.connect),
+			formatPingDuration(p.login), formatPingDuration(p.roundTrip))
+		if p.err != nil {
+			fmt.Fprintf(tw, "%v", p.err)
+			code = EX_NOHOST
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	return code
+}
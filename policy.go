@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Script on the remote that administrators may install to refuse or
+// adapt incoming commands.
+const policyFile = "${XDG_CONFIG_HOME:-$HOME/.config}/cpu/remote-policy"
+
+// Status the remote shell exits with when the policy refuses the
+// command, EX_NOPERM of sysexits(3).
+const policyRefused = 77
+
+// Consults the remote's policy script before cmd is run in the working
+// directory, if there is one.  The script is given the command in
+// CPU_COMMAND and the directory in CPU_DIR, and sees the forwarded
+// variables exported before it.  It refuses the command by exiting
+// non-zero, and may print dir=PATH to run it elsewhere or nice=N to
+// lower its priority, which is left in $n for the command prefix.
+func makePolicyCmd(cmd string) string {
+	return fmt.Sprintf(`{ pol=%s; n=; if [ -x "$pol" ]; then `+
+		`o=$(CPU_DIR="$PWD" CPU_COMMAND=%s "$pol") || { echo "cpu: refused by $pol" >&2; exit %d; }; `+
+		`d=$(printf '%%s\n' "$o" | sed -n 's/^dir=//p' | tail -n 1); `+
+		`n=$(printf '%%s\n' "$o" | sed -n 's/^nice=//p' | tail -n 1); `+
+		`[ -z "$d" ] || cd "$d"; fi; } && `, policyFile, rcpu.Quote(cmd), policyRefused)
+}
+
+// Runs the command at the niceness asked for by the policy.
+const policyNice = `${n:+nice -n "$n"} `
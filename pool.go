@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sny.no/cpu/rcpu"
+)
+
+var pick = flag.String("pick", os.Getenv("CPU_PICK"),
+	"`strategy` for picking a remote from a group: least-loaded, the default, first to answer, or random")
+
+// Prefixes of remotes naming a group of machines, of which one is
+// picked to run the command: @<name>[:<path>] or pool:<name>[:<path>].
+const (
+	groupPrefix = "@"
+	poolPrefix  = "pool:"
+)
+
+// Reports whether remote names a group rather than a machine.
+func isGroup(remote string) bool {
+	return strings.HasPrefix(remote, groupPrefix) || strings.HasPrefix(remote, poolPrefix)
+}
+
+// File of groups of interchangeable remotes, one per line:
+//
+//	name remote...
+//
+// where each remote is of the form [<user>@]<host>[#<port>].
+func poolsFile() string {
+	return configFile("pools")
+}
+
+// Returns the remotes in the named group of the -inventory, or else
+// of the pools file.
+func readPool(name string) ([]string, error) {
+	if remotes, ok := inventoryGroup(name); ok {
+		return remotes, nil
+	}
+	f, err := os.Open(poolsFile())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 1 && fields[0] == name {
+			return fields[1:], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no group %q in %s", name, poolsFile())
+}
+
+// Returns the load average over the last minute on remote per
+// processor it has.
+func loadPerProcessor(remote string) (float64, error) {
+	r, err := rcpu.ParseRemote(remote)
+	if err != nil {
+		return 0, err
+	}
+	args := append(makeSshOptions(), "-o", "ConnectTimeout=5", "-o", "BatchMode=yes")
+	if r.Port != "" {
+		args = append(args, "-o", "Port="+r.Port)
+	}
+	args = append(args, "-T", r.Login, "cut -d ' ' -f 1 /proc/loadavg; nproc")
+	out, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		return 0, err
+	}
+	f := strings.Fields(string(out))
+	if len(f) != 2 {
+		return 0, fmt.Errorf("unexpected output %q", out)
+	}
+	load, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	nproc, err := strconv.Atoi(f[1])
+	if err != nil || nproc < 1 {
+		return 0, fmt.Errorf("unexpected output %q", out)
+	}
+	return load / float64(nproc), nil
+}
+
+// @<name>[:<path>] -> the remote of the group picked by -pick, with path
+//
+// For least-loaded and first, the remotes are asked for their load at
+// once, and those that do not answer are passed over.
+func pickFromPool(remote string) string {
+	spec := strings.TrimPrefix(strings.TrimPrefix(remote, groupPrefix), poolPrefix)
+	name, path, hasPath := strings.Cut(spec, ":")
+	members, err := readPool(name)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+
+	var picked string
+	switch *pick {
+	case "random":
+		picked = members[rand.Intn(len(members))]
+		fmt.Fprintf(os.Stderr, "%s: picked %s from %s at random\n", os.Args[0], colorHost(picked, os.Stderr), name)
+	case "", "least-loaded", "first":
+		picked = pickByLoad(name, members)
+	default:
+		exit(EX_USAGE, "-pick must be least-loaded, first, or random")
+	}
+	if hasPath {
+		return picked + ":" + path
+	}
+	return picked
+}
+
+// Returns every remote of the group with the path of remote, if it
+// has one.
+func groupMembers(remote string) []string {
+	spec := strings.TrimPrefix(strings.TrimPrefix(remote, groupPrefix), poolPrefix)
+	name, path, hasPath := strings.Cut(spec, ":")
+	members, err := readPool(name)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+	if hasPath {
+		for i := range members {
+			members[i] += ":" + path
+		}
+	}
+	return members
+}
+
+// Returns the first remote of the group to answer in the order they
+// are listed, or with -pick least-loaded the one with the lowest load
+// per processor.
+func pickByLoad(name string, members []string) string {
+	loads := make([]float64, len(members))
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m string) {
+			defer wg.Done()
+			loads[i], errs[i] = loadPerProcessor(m)
+		}(i, m)
+	}
+	wg.Wait()
+
+	best := -1
+	for i, m := range members {
+		if errs[i] != nil {
+			explainf("passing over %s in %s: %v", m, name, errs[i])
+			continue
+		}
+		explainf("%s in %s has a load of %.2f per processor", m, name, loads[i])
+		if best < 0 || *pick != "first" && loads[i] < loads[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		exit(EX_NOHOST, "no remote in %s answered", name)
+	}
+	fmt.Fprintf(os.Stderr, "%s: picked %s from %s, with a load of %.2f per processor\n",
+		os.Args[0], colorHost(members[best], os.Stderr), name, loads[best])
+	return members[best]
+}
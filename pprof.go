@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"sny.no/cpu/rcpu"
+)
+
+// pprof fetches a profile from a pprof endpoint of a program running on
+// the remote, through a forwarded port, and opens it in the local pprof
+// web UI.  Source paths under the remote directory are mapped to the
+// local directory, so pprof shows the local sources.
+func pprof(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("pprof", flag.ExitOnError)
+	http := fs.String("http", "localhost:0", "serve the pprof web UI on `address`")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s pprof [-http address] [host]:port [profile]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return EX_USAGE
+	}
+	target := fs.Arg(0)
+	if !strings.Contains(target, ":") {
+		target = ":" + target
+	}
+	if strings.HasPrefix(target, ":") {
+		target = "localhost" + target
+	}
+	profile := "profile"
+	if fs.NArg() == 2 {
+		profile = fs.Arg(1)
+	}
+
+	tool := []string{"go", "tool", "pprof"}
+	if _, err := exec.LookPath("go"); err != nil {
+		tool = []string{"pprof"}
+	}
+
+	out, err := remoteOutput(login, "cd "+rcpu.MapPath(path)+" && pwd")
+	if err != nil {
+		exit(EX_NOHOST, "%s: %v", login, err)
+	}
+	remoteDir := strings.TrimSpace(string(out))
+	localDir, _ := os.Getwd()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		exit(EX_NOHOST, "%v", err)
+	}
+	local := l.Addr().String()
+	l.Close()
+
+	spec := fmt.Sprintf("%s:%s", local, target)
+	tunnel := exec.Command("ssh", append(makeSshOptions(), "-N", "-o ExitOnForwardFailure=yes", "-L", spec, login)...)
+	if verbose >= logCommands {
+		log.Println(tunnel)
+	}
+	explainf("forwarding %s to %s on %s", local, target, login)
+	if err := tunnel.Start(); err != nil {
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	defer func() {
+		tunnel.Process.Kill()
+		tunnel.Wait()
+	}()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if c, err := net.Dial("tcp", local); err == nil {
+			c.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			exit(EX_NOHOST, "pprof: forwarding to %s on %s timed out", target, login)
+		}
+	}
+
+	url := fmt.Sprintf("http://%s/debug/pprof/%s", local, profile)
+	args = append(tool, "-http="+*http, "-trim_path="+remoteDir, "-source_path="+localDir, url)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	explainf("executing %s", cmd)
+
+	// leave interrupts to pprof, so the tunnel is torn down after it exits
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+	if err := cmd.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode()
+		}
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"sny.no/cpu/rcpu"
+)
+
+var presence = flag.Bool("presence", false,
+	"let other users of the remote see this command and its directory with cpu who")
+
+// Directory shared by all users of a remote, holding a file for each
+// running cpu command and the latest message from each user.
+const presenceDir = "/tmp/cpu-presence"
+
+// File recording when the user last read the messages of others.
+const messagesRead = cacheRoot + "/messages-read"
+
+// Makes presenceDir unless it exists, and sets ok only if it is the
+// sticky directory anyone can write to, owned by root or the user, as
+// another user could have made it to replace the files in it.
+const checkPresenceDir = `p=%s; mkdir -m 1777 $p 2>/dev/null; set -- $(ls -ldn $p); ` +
+	`case $1 in drwxrwxrwt*) ;; *) false;; esac && { [ "$3" = "$(id -u)" ] || [ "$3" = 0 ]; } && ok=1 || ok=; `
+
+// Writes standard input to the file $1 in presenceDir through a
+// temporary file renamed over it, so that a symbolic link another user
+// planted there is replaced rather than followed.
+const presenceWriteFunc = `_cpu_put() { t=$(mktemp $p/.tmp.XXXXXX) && cat > $t && chmod 644 $t && mv -f $t "$1" || rm -f $t; }; `
+
+// Shows the message files of other users with control characters,
+// which could drive the terminal, removed.
+const presenceShowMessages = `LC_ALL=C tr -d '\000-\010\013-\037\177' < "$m" >&2`
+
+// Registers the command in the presence directory for as long as it
+// runs and shows messages from other users that have not been seen.
+func makePresenceCmd(cwd string, args []string) string {
+	if !*presence {
+		return ""
+	}
+	entry := fmt.Sprintf("%s\t%s", cwd, strings.Join(args, " "))
+	return fmt.Sprintf(checkPresenceDir+presenceWriteFunc+`u=$(id -un); f=$p/$u.$$; [ -n "$ok" ] && { `+
+		`printf '%%s\t%%s\t%%s\n' "$u" "$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)" %s | _cpu_put $f; `+
+		`r=%s; mkdir -p "${r%%/*}"; for m in $p/msg.*; do [ -f "$m" ] && [ "$m" != $p/msg.$u ] && [ "$m" -nt "$r" ] && %s; done; touch "$r"; }; `,
+		presenceDir, rcpu.Quote(entry), messagesRead, presenceShowMessages)
+}
+
+// who lists the cpu commands currently running on the remote.
+func who(login string, path string, args []string) int {
+	list := fmt.Sprintf(`for f in %s/*.*; do case $f in */msg.*) continue;; esac; `+
+		`[ -d /proc/${f##*.} ] && cat "$f"; done 2>/dev/null; cat %s/msg.* 2>/dev/null | sed 's/^/# /'`,
+		presenceDir, presenceDir)
+	out, err := remoteOutput(login, list)
+	if err != nil {
+		exit(EX_NOHOST, "%s: %v", login, err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tSINCE\tDIRECTORY\tCOMMAND")
+	var messages []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = stripControl(line)
+		if strings.HasPrefix(line, "# ") {
+			messages = append(messages, strings.TrimPrefix(line, "# "))
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, fields[1]); err == nil {
+			fields[1] = time.Since(t).Round(time.Minute).String()
+		}
+		fmt.Fprintln(tw, strings.Join(fields, "\t"))
+	}
+	tw.Flush()
+	for _, msg := range messages {
+		fmt.Println(msg)
+	}
+	return 0
+}
+
+// wall leaves a message for the other cpu users of the remote, shown
+// when they next run a command there and in cpu who.
+func wall(login string, path string, args []string) int {
+	if len(args) == 0 {
+		exit(EX_USAGE, "missing message")
+	}
+	msg := fmt.Sprintf("%s: %s", time.Now().Format("15:04 MST"), strings.Join(args, " "))
+	post := fmt.Sprintf(checkPresenceDir+presenceWriteFunc+`[ -n "$ok" ] || { echo "cpu: $p is not a shared sticky directory" >&2; exit 77; }; `+
+		`u=$(id -un); printf '%%s from %%s\n' %s "$u" | _cpu_put $p/msg.$u`,
+		presenceDir, rcpu.Quote(msg))
+	if _, err := remoteOutput(login, post); err != nil {
+		exit(EX_NOHOST, "%s: %v", login, err)
+	}
+	return 0
+}
+
+// Removes the control characters other than tab from s, so that what
+// other users wrote cannot drive the terminal.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
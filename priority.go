@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	niceness = flag.Int("nice", 0, "run the remote command at this niceness `increment`")
+	ioClass  = flag.String("ionice", "",
+		"run the remote command in this I/O scheduling `class`: idle, best-effort or realtime, with an optional :level")
+	umask = flag.String("umask", "", "set the file mode creation `mask` of the remote command, such as 027")
+)
+
+var umaskMode = regexp.MustCompile(`^[0-7]{1,4}$`)
+
+// Programs lowering the priority of the command on shared remotes,
+// ending in a space.
+func makePriorityPrefix() string {
+	var prefix string
+	if *niceness != 0 {
+		explainf("running the command at niceness %d", *niceness)
+		prefix += fmt.Sprintf("nice -n %d ", *niceness)
+	}
+	if *ioClass != "" {
+		class, level, hasLevel := strings.Cut(*ioClass, ":")
+		switch class {
+		case "idle", "best-effort", "realtime":
+		default:
+			exit(EX_USAGE, "-ionice class must be idle, best-effort, or realtime")
+		}
+		explainf("running the command in the %s I/O scheduling class", class)
+		prefix += "ionice -c " + class + " "
+		if hasLevel {
+			if len(level) != 1 || level[0] < '0' || level[0] > '7' {
+				exit(EX_USAGE, "-ionice level must be between 0 and 7")
+			}
+			prefix += "-n " + level + " "
+		}
+	}
+	return prefix
+}
+
+// Sets the -umask before the command is run.
+func makeUmaskCmd() string {
+	if *umask == "" {
+		return ""
+	}
+	if !umaskMode.MatchString(*umask) {
+		exit(EX_USAGE, "-umask must be an octal mode such as 027")
+	}
+	return "umask " + *umask + " && "
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File of named profiles, each a section of settings for reaching a
+// remote, named by @<profile>[:<path>] in place of the remote:
+//
+//	[work]
+//	host = build.example.com
+//	user = me
+//	port = 2222
+//	ssh-args = -J bastion.example.com -o Compression=yes
+//	path = ~/work=~/src
+//	sendenv = true
+//	env-file = /home/me/.config/cpu/work.env
+//
+// host, user and port make up the remote, unless it is given whole as
+// remote.  ssh-args are passed to ssh in place of CPU_SSH_ARGS, and
+// each path maps a local directory, and those under it, to one on the
+// remote.  Any other setting is one of cpu's flags, and applies unless
+// the flag is given on the command line.  A profile takes precedence
+// over a group of the same name.
+func profilesFile() string {
+	return configFile("profiles")
+}
+
+// profile is a section of the profiles file.
+type profile struct {
+	name     string
+	settings map[string]string
+	paths    [][2]string
+}
+
+func readProfiles(name string) (map[string]*profile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles := map[string]*profile{}
+	var p *profile
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			p = &profile{name: line[1 : len(line)-1], settings: map[string]string{}}
+			profiles[p.name] = p
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || p == nil {
+			return nil, fmt.Errorf("%s:%d: expected a setting of a profile, as key = value", name, n)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "path" {
+			local, remote, ok := strings.Cut(v, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: path must be local=remote", name, n)
+			}
+			p.paths = append(p.paths, [2]string{strings.TrimSpace(local), strings.TrimSpace(remote)})
+			continue
+		}
+		p.settings[k] = unquoteVar(v)
+	}
+	return profiles, s.Err()
+}
+
+// Returns the profile named by remote, or nil if it does not name one.
+func lookupProfile(remote string) *profile {
+	name, ok := strings.CutPrefix(remote, groupPrefix)
+	if !ok {
+		return nil
+	}
+	name, _, _ = strings.Cut(name, ":")
+	profiles, err := readProfiles(profilesFile())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	return profiles[name]
+}
+
+// Returns the remote of the profile, without a path.
+func (p *profile) remote() string {
+	if r := p.settings["remote"]; r != "" {
+		return r
+	}
+	addr := p.settings["host"]
+	if addr == "" {
+		exit(EX_DATAERR, "profile %s in %s has neither host nor remote", p.name, profilesFile())
+	}
+	if strings.Contains(addr, ":") {
+		addr = "[" + addr + "]"
+	}
+	if u := p.settings["user"]; u != "" {
+		addr = u + "@" + addr
+	}
+	if port := p.settings["port"]; port != "" {
+		addr += "#" + port
+	}
+	return addr
+}
+
+// Maps dir to the remote by the longest of the profile's paths it is
+// under.
+func (p *profile) mapPath(dir string) (string, bool) {
+	home, _ := os.UserHomeDir()
+	best, mapped := -1, ""
+	for _, m := range p.paths {
+		local := m[0]
+		if rest, ok := strings.CutPrefix(local, "~"); ok {
+			local = home + rest
+		}
+		local = filepath.Clean(local)
+		rel, err := filepath.Rel(local, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(local) > best {
+			best, mapped = len(local), strings.TrimSuffix(m[1], "/")
+			if rel != "." {
+				mapped += "/" + filepath.ToSlash(rel)
+			}
+		}
+	}
+	return mapped, best >= 0
+}
+
+// Extra arguments to ssh(1) given by the profile in use, which replace
+// CPU_SSH_ARGS.
+var profileSshArgs string
+
+// Returns the extra arguments to ssh(1), from the profile in use or
+// else CPU_SSH_ARGS, and where they come from.
+func extraSshArgs() (string, string) {
+	if profileSshArgs != "" {
+		return profileSshArgs, "the profile's ssh-args"
+	}
+	return os.Getenv("CPU_SSH_ARGS"), "CPU_SSH_ARGS"
+}
+
+// @<profile>[:<path>] -> the profile's remote, with path or else the
+// working directory as mapped by the profile
+//
+// The profile's settings are applied on the way.  Remotes not naming
+// a profile are left as they are.
+func resolveProfile(remote string) string {
+	p := lookupProfile(remote)
+	if p == nil {
+		return remote
+	}
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for k, v := range p.settings {
+		switch k {
+		case "remote", "host", "user", "port":
+		case "ssh-args":
+			profileSshArgs = v
+		default:
+			if flag.Lookup(k) == nil {
+				exit(EX_DATAERR, "profile %s in %s: unknown setting %s", p.name, profilesFile(), k)
+			}
+			if given[k] {
+				explainf("-%s on the command line overrides profile %s", k, p.name)
+				continue
+			}
+			if err := flag.Set(k, v); err != nil {
+				exit(EX_DATAERR, "profile %s in %s: %s: %v", p.name, profilesFile(), k, err)
+			}
+			explainf("profile %s sets -%s=%s", p.name, k, v)
+		}
+	}
+
+	r := p.remote()
+	if _, path, ok := strings.Cut(remote, ":"); ok {
+		r += ":" + path
+	} else if cwd, err := os.Getwd(); err == nil {
+		if path, ok := p.mapPath(cwd); ok {
+			explainf("profile %s maps %s to %s", p.name, cwd, path)
+			r += ":" + path
+		}
+	}
+	explainf("profile %s is %s", p.name, r)
+	return r
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sny.no/cpu/rcpu"
+)
+
+var promptMarker = flag.String("prompt", os.Getenv("CPU_PROMPT"),
+	"prefix the prompt of interactive remote bash and zsh shells with `marker`, in which {host} is the remote's host name")
+
+// Keeps the prompt bash's rc files set and prefixes it with the marker,
+// in the colour of the host after a command succeeded and red after
+// one failed.
+const bashPromptCommand = `cpu_s=$?; [ -n "${cpu_ps1+x}" ] || cpu_ps1=$PS1; ` +
+	`if [ $cpu_s = 0 ]; then cpu_c="38;5;$CPU_HOST_COLOR"; else cpu_c=31; fi; ` +
+	`PS1="\[\e[${cpu_c}m\]$CPU_PROMPT_MARKER\[\e[0m\]$cpu_ps1"; (exit $cpu_s)`
+
+// Files standing in for zsh's own in the ZDOTDIR made for the session,
+// which read the user's and then prefix the prompt with the marker.
+var zshFiles = map[string]string{
+	".zshenv": `[ -f "$HOME/.zshenv" ] && . "$HOME/.zshenv"`,
+	".zshrc": `ZDOTDIR=$HOME; [ -f "$HOME/.zshrc" ] && . "$HOME/.zshrc"; ` +
+		`PROMPT="%(?.%F{$CPU_HOST_COLOR}.%F{red})$CPU_PROMPT_MARKER%f$PROMPT"`,
+}
+
+// Whether shells started by the command get the -prompt marker.
+func wantPrompt() bool {
+	return *promptMarker != "" && wantTTY()
+}
+
+// Makes interactive bash and zsh shells started by the command show
+// the -prompt marker, so that they are not mistaken for local ones.
+// bash is given a PROMPT_COMMAND, which rc files setting their own
+// override, and zsh a ZDOTDIR in $pd, removed again on exit.
+func makePromptCmd() string {
+	if !wantPrompt() {
+		return ""
+	}
+	explainf("prefixing the prompt of remote shells with %q", *promptMarker)
+	zdotdir := "pd=$(mktemp -d 2>/dev/null) && "
+	for _, name := range []string{".zshenv", ".zshrc"} {
+		zdotdir += fmt.Sprintf("printf '%%s\\n' %s > $pd/%s && ", rcpu.Quote(zshFiles[name]), name)
+	}
+	return fmt.Sprintf(`export CPU_PROMPT_MARKER="$(printf '%%s' %s | sed "s/{host}/$(uname -n | cut -d. -f1)/g")" `+
+		"CPU_HOST_COLOR=%d PROMPT_COMMAND=%s; { %sexport ZDOTDIR=$pd; }; ",
+		rcpu.Quote(*promptMarker), hostColorNumber(remoteLogin), rcpu.Quote(bashPromptCommand), zdotdir)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var quota = flag.String("quota", os.Getenv("CPU_QUOTA"),
+	"largest `size` cpu may use on the remote for scratch directories and overlays, such as 20G")
+
+// Everything cpu keeps on the remote.
+const cacheRoot = "${XDG_CACHE_HOME:-$HOME/.cache}/cpu"
+
+// Parses a size such as 512M or 20G into KiB.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			mult = 1
+		case "M":
+			mult = 1 << 10
+		case "G":
+			mult = 1 << 20
+		case "T":
+			mult = 1 << 30
+		default:
+			return strconv.ParseInt(s, 10, 64)
+		}
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n * mult, err
+}
+
+// Scratch directories and overlays made for this run, which are locked
+// against eviction while it uses them.
+var lockedDirs []string
+
+// Returns the lock file of a scratch directory or overlay, hidden next
+// to it so that it is neither listed with them nor synced into them.
+// It holds the process ID of the remote shell using the directory, and
+// is empty from when it is made until that shell starts.
+func lockFile(dir string) string {
+	return path.Dir(dir) + "/." + path.Base(dir) + ".lock"
+}
+
+// Shell text making the lock file of the directory in $d.
+const makeLockCmd = `: > "${d%/*}/.${d##*/}.lock"`
+
+// Has the remote shell put its process ID in the lock files of the
+// directories of this run, so that they are not evicted while it
+// runs, including while a deferred job waits to start.
+func makeLockDirsCmd() string {
+	cmd := ""
+	for _, dir := range lockedDirs {
+		cmd += fmt.Sprintf("echo $$ > %s; ", lockFile(dir))
+	}
+	return cmd
+}
+
+// Removes the least recently used scratch directories and overlays on
+// login until cpu's usage there is within -quota.  Those in use are
+// skipped: their lock file names a running shell, or it is empty and
+// less than an hour old, as when the directory was just made.  Mount
+// points are counted only for the directories, not for the mounted
+// files.
+func enforceQuota(login string) {
+	if *quota == "" {
+		return
+	}
+	limit, err := parseSize(*quota)
+	if err != nil {
+		exit(EX_USAGE, "invalid -quota %q", *quota)
+	}
+
+	evict := fmt.Sprintf(`root=%s; total=$(du -skx "$root" 2>/dev/null | cut -f1); [ -n "$total" ] || exit 0
+for d in $(ls -1tdr "$root"/scratch/* "$root"/overlay/* 2>/dev/null); do
+	[ "$total" -le %d ] && break
+	l=${d%%/*}/.${d##*/}.lock
+	if [ -f "$l" ]; then
+		p=$(cat "$l")
+		[ -n "$p" ] && kill -0 "$p" 2>/dev/null && continue
+		[ -z "$p" ] && [ -n "$(find "$l" -mmin -60)" ] && continue
+	fi
+	size=$(du -skx "$d" | cut -f1)
+	chmod -R u+rwx "$d" && rm -rf "$d" "$l" && total=$((total - size)) && echo "$d"
+done`, cacheRoot, limit)
+	out, err := remoteOutput(login, evict)
+	if err != nil {
+		log.Println("quota:", err)
+	}
+	for _, d := range strings.Fields(string(out)) {
+		explainf("removed %s to stay within the quota of %s", d, *quota)
+		if verbose >= logCommands {
+			log.Println("quota: removed", d)
+		}
+	}
+}
+
+// status reports how much space cpu uses on the remote.
+func status(login string, path string, args []string) int {
+	usage := fmt.Sprintf(`root=%s; [ -d "$root" ] || exit 0; cd "$root" && du -shx * 2>/dev/null; du -shx . | sed 's/\.$/total/'`,
+		cacheRoot)
+	out, err := remoteOutput(login, usage)
+	if err != nil {
+		exit(EX_NOHOST, "%s: %v", login, err)
+	}
+	fmt.Printf("%s:\n%s", login, out)
+	if *quota != "" {
+		fmt.Printf("quota\t%s\n", *quota)
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sny.no/cpu/rcpu"
+)
+
+var rcFile = flag.Bool("rcfile", os.Getenv("CPU_RCFILE") != "",
+	"run the command with a minimal rc file and ~/.config/cpu/rc on the remote instead of the remote's interactive rc files")
+
+// Additions to the minimal rc file the remote user may keep.
+const rcAdditions = "${XDG_CONFIG_HOME:-$HOME/.config}/cpu/rc"
+
+// Puts the usual directories of per-user programs on the PATH, which
+// the interactive rc files would otherwise have done, makes aliases
+// work as in an interactive shell, and reads the user's additions.
+var minimalRC = fmt.Sprintf(`for d in "$HOME/bin" "$HOME/.local/bin" "$HOME/.cargo/bin" "$HOME/go/bin"; do `+
+	`[ -d "$d" ] && case :$PATH: in *:"$d":*) ;; *) PATH=$d:$PATH;; esac; done; export PATH; `+
+	`shopt -s expand_aliases; rc=%s; [ ! -r "$rc" ] || . "$rc"`, rcAdditions)
+
+// Runs cmd in a bash that reads the minimal rc file rather than the
+// remote's, which may be slow or print things that would end up in
+// the command's output.
+func makeMinimalRCWrapper(cmd string) string {
+	explainf("running the command in bash with a minimal rc file and %s instead of the remote's rc files", rcAdditions)
+	// aliases defined in the rc file only apply from the next line
+	return fmt.Sprintf("bash --norc --noprofile -c %s", rcpu.Quote(minimalRC+"\n"+cmd))
+}
@@ -0,0 +1,401 @@
+/*
+Package rcpu runs commands on remote machines the way cpu does, for
+tools that want cpu's behaviour without shelling out to the binary.
+
+A Remote is parsed from the same specifications cpu accepts with -r,
+and a Session runs commands on it over ssh(1) in the directory that
+corresponds to a local one:
+
+	r, err := rcpu.ParseRemote("ato@buildmachine#2222:~/src/gecko")
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := rcpu.NewSession(r)
+	code, err := s.Run([]string{"./mach", "build"}, &rcpu.RunOptions{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	})
+
+Paths under the local home directory are mapped to the same path under
+the remote home directory, as with cpu.
+*/
+package rcpu // import "sny.no/cpu/rcpu"
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Remote is a remote machine and, optionally, a directory on it.
+type Remote struct {
+	// Login is the ssh(1) destination, such as ato@buildmachine.
+	// IPv6 addresses are given without brackets.
+	Login string
+
+	// Port is the port to connect to, or "" for the default.
+	Port string
+
+	// Path is the directory to run in, or "" to map the local
+	// working directory.
+	Path string
+}
+
+// ParseRemote parses a remote in one of these forms:
+//
+//	[<user>@]<host>[#<port>][:<path>]
+//	ssh://[<user>@]<host>[:<port>][/<path>]
+//
+// IPv6 addresses are given in brackets, which are removed from the login.
+func ParseRemote(remote string) (*Remote, error) {
+	var user, host, port, path string
+
+	rest := remote
+	url := strings.HasPrefix(rest, "ssh://")
+	if url {
+		rest = strings.TrimPrefix(rest, "ssh://")
+	}
+	if i := strings.IndexAny(rest, "@[:/#"); i >= 0 && rest[i] == '@' {
+		user, rest = rest[:i+1], rest[i+1:]
+	}
+
+	if strings.HasPrefix(rest, "[") {
+		i := strings.Index(rest, "]")
+		if i < 0 {
+			return nil, fmt.Errorf("unterminated IPv6 address in remote %q", remote)
+		}
+		host, rest = rest[1:i], rest[i+1:]
+	} else {
+		seps := "#:"
+		if url {
+			seps = ":/"
+		}
+		i := strings.IndexAny(rest, seps)
+		if i < 0 {
+			i = len(rest)
+		}
+		host, rest = rest[:i], rest[i:]
+	}
+
+	portSep, pathSep := "#", ":"
+	if url {
+		portSep, pathSep = ":", "/"
+	}
+	if strings.HasPrefix(rest, portSep) {
+		rest = rest[1:]
+		i := strings.Index(rest, pathSep)
+		if i < 0 {
+			i = len(rest)
+		}
+		port, rest = rest[:i], rest[i:]
+		if port == "" {
+			return nil, fmt.Errorf("missing port in remote %q", remote)
+		}
+	}
+	if strings.HasPrefix(rest, pathSep) {
+		path = rest[len(pathSep):]
+		if url {
+			// ssh://host/~/src is relative to the home directory
+			// and ssh://host/src is absolute
+			if !strings.HasPrefix(path, "~") {
+				path = "/" + path
+			}
+		}
+		if path == "" {
+			path = "~"
+		}
+	} else if rest != "" {
+		return nil, fmt.Errorf("malformed remote %q", remote)
+	}
+
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in remote %q: %s", remote, port)
+		}
+	}
+	return &Remote{Login: user + host, Port: port, Path: path}, nil
+}
+
+// HostPath formats path on login in the syntax of scp(1) and
+// rsync(1), which need IPv6 addresses in brackets.
+func HostPath(login string, path string) string {
+	i := strings.LastIndex(login, "@") + 1
+	if strings.Contains(login[i:], ":") {
+		login = login[:i] + "[" + login[i:] + "]"
+	}
+	return login + ":" + path
+}
+
+// MapPath maps a local path to the remote.  Paths under the current
+// user's home directory are made relative to ~ so that they refer to
+// the remote home directory.  Windows paths are given forward slashes
+// and lose their drive letter.
+func MapPath(path string) string {
+	usr, err := user.Current()
+	if err != nil {
+		return toSlashNoVolume(path)
+	}
+	home := usr.HomeDir
+	if len(path) >= len(home) && (path[:len(home)] == home ||
+		runtime.GOOS == "windows" && strings.EqualFold(path[:len(home)], home)) {
+		relPath := path[len(home):]
+		return fmt.Sprintf("~%s", filepath.ToSlash(relPath))
+	}
+	return toSlashNoVolume(path)
+}
+
+func toSlashNoVolume(path string) string {
+	return filepath.ToSlash(strings.TrimPrefix(path, filepath.VolumeName(path)))
+}
+
+// Quote quotes s for the shell so that it is taken literally as one word.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Expansion says where the variables in a command are expanded.
+type Expansion int
+
+const (
+	// ExpandRemote leaves the command to the remote shell, which
+	// expands its variables and globs.
+	ExpandRemote Expansion = iota
+
+	// ExpandLocal replaces $NAME and ${NAME} by the values of local
+	// variables, quoted so that the remote shell takes them as they
+	// are.  Variables in single quotes are left alone, as the shell
+	// would, and $$ stands for a $ left to the remote shell.
+	ExpandLocal
+
+	// ExpandNone quotes each argument, so that the remote runs the
+	// command as given, without expanding anything.
+	ExpandNone
+)
+
+// Join joins args into a command for the remote shell, expanding
+// variables in it as exp says, with getenv for local variables.
+func Join(args []string, exp Expansion, getenv func(string) string) string {
+	switch exp {
+	case ExpandLocal:
+		return expandVars(strings.Join(args, " "), getenv)
+	case ExpandNone:
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = Quote(arg)
+		}
+		return strings.Join(quoted, " ")
+	}
+	return strings.Join(args, " ")
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// Reports whether s is a variable name, rather than a parameter such
+// as $1 or ${x:-y} that is left to the remote.
+func isName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isNameByte(s[i], i == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Replaces the variables in cmd outside single quotes by their values
+// from getenv.
+func expandVars(cmd string, getenv func(string) string) string {
+	var b strings.Builder
+	single, double := false, false
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case single:
+			single = c != '\''
+		case c == '\\' && i+1 < len(cmd):
+			b.WriteByte(c)
+			i++
+			c = cmd[i]
+		case c == '\'' && !double:
+			single = true
+		case c == '"':
+			double = !double
+		case c == '$' && i+1 < len(cmd) && cmd[i+1] == '$':
+			i++
+		case c == '$' && i+1 < len(cmd):
+			name, end := "", i+1
+			if cmd[end] == '{' {
+				if j := strings.IndexByte(cmd[end:], '}'); j > 0 {
+					name, end = cmd[end+1:end+j], end+j+1
+				}
+			} else {
+				for end < len(cmd) && isNameByte(cmd[end], end == i+1) {
+					end++
+				}
+				name = cmd[i+1 : end]
+			}
+			if !isName(name) {
+				break
+			}
+			value := getenv(name)
+			if double {
+				value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value)
+			} else {
+				value = Quote(value)
+			}
+			b.WriteString(value)
+			i = end - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// RunOptions controls how a Session runs a command.
+type RunOptions struct {
+	// Dir is the local directory whose remote counterpart the
+	// command runs in, if the Remote has no Path.  It defaults to
+	// the working directory.
+	Dir string
+
+	// Env holds additional KEY=value pairs for the command.
+	Env []string
+
+	// Shell is the local shell, such as /bin/bash, as for WrapShell.
+	Shell string
+
+	// Umask is the octal file mode creation mask for the command, or
+	// empty to keep the remote's.
+	Umask string
+
+	// TTY requests a pseudo-terminal on the remote.
+	TTY bool
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Session runs commands on a Remote.
+type Session struct {
+	Remote *Remote
+
+	// SSHArgs are extra arguments for every ssh(1) invocation, such
+	// as -o options.
+	SSHArgs []string
+}
+
+// NewSession returns a Session for r passing sshArgs to ssh(1).
+func NewSession(r *Remote, sshArgs ...string) *Session {
+	return &Session{Remote: r, SSHArgs: sshArgs}
+}
+
+func (s *Session) sshArgs() []string {
+	var args []string
+	if s.Remote.Port != "" {
+		args = append(args, "-o", "Port="+s.Remote.Port)
+	}
+	return append(args, s.SSHArgs...)
+}
+
+// CommandLine returns the shell command run on the remote for args,
+// as cpu runs it without its optional additions.
+func CommandLine(dir string, args []string, opts *RunOptions) string {
+	umask := ""
+	if opts.Umask != "" {
+		umask = "umask " + opts.Umask + " && "
+	}
+	return fmt.Sprintf("{ cd %s && %s%s%s; }", dir, umask, Exports(opts.Env), WrapShell(opts.Shell, strings.Join(args, " ")))
+}
+
+// WrapShell returns the shell command cmd run the way the local shell
+// would run it.  With bash it is run in an interactive bash for its rc
+// files, and otherwise with sh -c, as the remote login shell may not
+// take the same syntax.
+func WrapShell(shell string, cmd string) string {
+	if path.Base(shell) == "bash" {
+		return "bash -ci " + Quote(cmd)
+	}
+	return "sh -c " + Quote(cmd)
+}
+
+// Exports returns a shell command exporting the KEY=value pairs of
+// env, with the values quoted, followed by &&, or nothing if env is
+// empty.
+func Exports(env []string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("export")
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		b.WriteString(" " + k + "=" + Quote(v))
+	}
+	b.WriteString(" && ")
+	return b.String()
+}
+
+// Command returns the ssh(1) command that runs args on the remote.
+// Like cpu, it does not quote args, so that they may use the syntax
+// of the remote shell.
+func (s *Session) Command(args []string, opts *RunOptions) (*exec.Cmd, error) {
+	if opts == nil {
+		opts = &RunOptions{}
+	}
+	dir := s.Remote.Path
+	if dir == "" {
+		dir = opts.Dir
+		if dir == "" {
+			var err error
+			if dir, err = os.Getwd(); err != nil {
+				return nil, err
+			}
+		}
+		dir = MapPath(dir)
+	}
+
+	sshArgs := s.sshArgs()
+	if opts.TTY {
+		sshArgs = append(sshArgs, "-tt")
+	} else {
+		sshArgs = append(sshArgs, "-e", "none", "-T")
+	}
+	sshArgs = append(sshArgs, s.Remote.Login, CommandLine(dir, args, opts))
+
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = opts.Stdin, opts.Stdout, opts.Stderr
+	return cmd, nil
+}
+
+// Run runs args on the remote and returns its exit status.  The error
+// is only set if the command could not be run.
+func (s *Session) Run(args []string, opts *RunOptions) (int, error) {
+	cmd, err := s.Command(args, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Output runs the shell command cmd on the remote without a terminal
+// and returns its standard output.
+func (s *Session) Output(cmd string) ([]byte, error) {
+	args := append(s.sshArgs(), "-T", s.Remote.Login, cmd)
+	return exec.Command("ssh", args...).Output()
+}
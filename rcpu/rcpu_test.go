@@ -0,0 +1,107 @@
+package rcpu
+
+import "testing"
+
+func TestParseRemote(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   Remote
+	}{
+		{"buildmachine", Remote{Login: "buildmachine"}},
+		{"ato@buildmachine", Remote{Login: "ato@buildmachine"}},
+		{"ato@buildmachine#2222", Remote{Login: "ato@buildmachine", Port: "2222"}},
+		{"ato@buildmachine:~/src", Remote{Login: "ato@buildmachine", Path: "~/src"}},
+		{"ato@buildmachine#2222:~/src", Remote{Login: "ato@buildmachine", Port: "2222", Path: "~/src"}},
+		{"buildmachine#2222:/srv/src", Remote{Login: "buildmachine", Port: "2222", Path: "/srv/src"}},
+		{"buildmachine:", Remote{Login: "buildmachine", Path: "~"}},
+		{"[::1]", Remote{Login: "::1"}},
+		{"ato@[fe80::1]:/src", Remote{Login: "ato@fe80::1", Path: "/src"}},
+		{"ato@[fe80::1]#2222:/src", Remote{Login: "ato@fe80::1", Port: "2222", Path: "/src"}},
+		{"ssh://buildmachine", Remote{Login: "buildmachine"}},
+		{"ssh://ato@buildmachine:2222", Remote{Login: "ato@buildmachine", Port: "2222"}},
+		{"ssh://ato@buildmachine/~/src", Remote{Login: "ato@buildmachine", Path: "~/src"}},
+		{"ssh://ato@buildmachine:2222/srv/src", Remote{Login: "ato@buildmachine", Port: "2222", Path: "/srv/src"}},
+		{"ssh://[::1]:2222/~", Remote{Login: "::1", Port: "2222", Path: "~"}},
+		{"ssh://[::1]/srv", Remote{Login: "::1", Path: "/srv"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRemote(tt.remote)
+		if err != nil {
+			t.Errorf("ParseRemote(%q): %v", tt.remote, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParseRemote(%q) = %+v, want %+v", tt.remote, *got, tt.want)
+		}
+	}
+}
+
+func TestParseRemoteErrors(t *testing.T) {
+	for _, remote := range []string{
+		"[::1",
+		"ato@[::1]x",
+		"buildmachine#",
+		"buildmachine#ssh",
+		"buildmachine#0",
+		"buildmachine#65536:/src",
+		"ssh://buildmachine:http/src",
+		"ssh://buildmachine:99999",
+	} {
+		if r, err := ParseRemote(remote); err == nil {
+			t.Errorf("ParseRemote(%q) = %+v, want error", remote, *r)
+		}
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		opts RunOptions
+		want string
+	}{
+		{RunOptions{}, `{ cd ~/src && sh -c 'make -j'; }`},
+		{RunOptions{Shell: "/bin/bash"}, `{ cd ~/src && bash -ci 'make -j'; }`},
+		{RunOptions{Shell: "/usr/bin/fish", Umask: "002"}, `{ cd ~/src && umask 002 && sh -c 'make -j'; }`},
+		{
+			RunOptions{Env: []string{"CC=clang", "CFLAGS=-O2 -g", "MSG=it's"}},
+			`{ cd ~/src && export CC='clang' CFLAGS='-O2 -g' MSG='it'\''s' && sh -c 'make -j'; }`,
+		},
+	}
+	for _, tt := range tests {
+		if got := CommandLine("~/src", []string{"make", "-j"}, &tt.opts); got != tt.want {
+			t.Errorf("CommandLine(%+v) = %s, want %s", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	env := map[string]string{
+		"X": "a b",
+		"Q": "q\"$`\\",
+	}
+	getenv := func(name string) string { return env[name] }
+	tests := []struct {
+		args []string
+		exp  Expansion
+		want string
+	}{
+		{[]string{"echo", "$X"}, ExpandRemote, `echo $X`},
+		{[]string{"echo", "$X"}, ExpandLocal, `echo 'a b'`},
+		{[]string{"echo", "${X}"}, ExpandLocal, `echo 'a b'`},
+		{[]string{"echo", "'$X'"}, ExpandLocal, `echo '$X'`},
+		{[]string{"echo", `"$X"`}, ExpandLocal, `echo "a b"`},
+		{[]string{"echo", `"it's $X"`}, ExpandLocal, `echo "it's a b"`},
+		{[]string{"echo", `"$Q"`}, ExpandLocal, "echo \"q\\\"\\$\\`\\\\\""},
+		{[]string{"echo", "$Q"}, ExpandLocal, "echo 'q\"$`\\'"},
+		{[]string{"echo", "$$X"}, ExpandLocal, `echo $X`},
+		{[]string{"echo", `\$X`}, ExpandLocal, `echo \$X`},
+		{[]string{"echo", "${X:-y}"}, ExpandLocal, `echo ${X:-y}`},
+		{[]string{"echo", "$1", "$UNSET"}, ExpandLocal, `echo $1 ''`},
+		{[]string{"echo", "cost$"}, ExpandLocal, `echo cost$`},
+		{[]string{"echo", "$X", "it's"}, ExpandNone, `'echo' '$X' 'it'\''s'`},
+	}
+	for _, tt := range tests {
+		if got := Join(tt.args, tt.exp, getenv); got != tt.want {
+			t.Errorf("Join(%q, %d) = %s, want %s", tt.args, tt.exp, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var record = flag.String("record", "",
+	"record the session's output with its timing to `file` in asciinema's format")
+
+// recorder writes the output of a session as an asciicast v2 file,
+// which asciinema(1) can play back.
+type recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+}
+
+func newRecorder(name string) (*recorder, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	width, height := terminalSize(os.Stdout)
+	header := struct {
+		Version   int               `json:"version"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Timestamp int64             `json:"timestamp"`
+		Env       map[string]string `json:"env"`
+	}{2, width, height, time.Now().Unix(), map[string]string{
+		"SHELL": os.Getenv("SHELL"),
+		"TERM":  os.Getenv("TERM"),
+	}}
+	b, _ := json.Marshal(header)
+	if _, err := fmt.Fprintf(f, "%s\n", b); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &recorder{f: f, start: time.Now()}, nil
+}
+
+// Records an output event, unless r is nil.
+func (r *recorder) event(data string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), "o", data})
+	fmt.Fprintf(r.f, "%s\n", b)
+}
+
+func (r *recorder) close() error {
+	if r == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// Returns a writer recording everything written to w.
+func (r *recorder) watch(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	return &recordWriter{w: w, r: r}
+}
+
+// recordWriter passes output through to w and records it, holding
+// back incomplete UTF-8 sequences until the rest arrives.
+type recordWriter struct {
+	w       io.Writer
+	r       *recorder
+	partial []byte
+}
+
+func (rw *recordWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	data := append(rw.partial, p[:n]...)
+	end := len(data)
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				end = len(data) - i
+			}
+			break
+		}
+	}
+	rw.partial = append([]byte(nil), data[end:]...)
+	if end > 0 {
+		rw.r.event(string(data[:end]))
+	}
+	return n, err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var retries = flag.Int("retry", envInt("CPU_RETRY"),
+	"retry up to this many `times` when the connection fails, backing off between attempts")
+
+// Largest wait between attempts.
+const maxBackoff = time.Minute
+
+// Returns the integer in the environment variable name, or 0.
+func envInt(name string) int {
+	n, _ := strconv.Atoi(os.Getenv(name))
+	return n
+}
+
+// Waits before the given attempt at connecting, twice as long as
+// before the previous one.
+func backoff(attempt int) {
+	d := time.Second << (attempt - 1)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	fmt.Fprintf(os.Stderr, "%s: connection failed, retrying in %v (%d of %d)\n", os.Args[0], d, attempt, *retries)
+	time.Sleep(d)
+}
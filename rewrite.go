@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+var noRewrite = flag.Bool("no-rewrite", false,
+	"run the command as given, without applying the rewrite rules")
+
+// rewriteRule replaces the leading words of commands matching pattern,
+// on remotes matching host.
+type rewriteRule struct {
+	host        string
+	pattern     []string
+	replacement string
+}
+
+// File of rules for rewriting commands, one per line:
+//
+//	[@host] pattern => replacement
+func rewriteFile() string {
+	return configFile("rewrite")
+}
+
+func readRewriteRules() ([]rewriteRule, error) {
+	f, err := os.Open(rewriteFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []rewriteRule
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=>")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: missing =>", rewriteFile(), n)
+		}
+		r := rewriteRule{host: "*", replacement: strings.TrimSpace(line[i+2:])}
+		r.pattern = strings.Fields(line[:i])
+		if len(r.pattern) > 0 && strings.HasPrefix(r.pattern[0], "@") {
+			r.host, r.pattern = r.pattern[0][1:], r.pattern[1:]
+		}
+		if len(r.pattern) == 0 {
+			return nil, fmt.Errorf("%s:%d: missing pattern", rewriteFile(), n)
+		}
+		rules = append(rules, r)
+	}
+	return rules, s.Err()
+}
+
+// Reports whether the words start with the rule's pattern, each word
+// of which is matched as with path.Match.
+func (r rewriteRule) matches(login string, words []string) bool {
+	if ok, _ := path.Match(r.host, login); !ok {
+		ok, _ = path.Match(r.host, hostOf(login))
+		if !ok {
+			return false
+		}
+	}
+	if len(words) < len(r.pattern) {
+		return false
+	}
+	for i, p := range r.pattern {
+		if ok, _ := path.Match(p, words[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns login without the user.
+func hostOf(login string) string {
+	return login[strings.LastIndex(login, "@")+1:]
+}
+
+// Applies the first rewrite rule matching command on login, filling in
+// {nproc} and {memory}, in GiB, with facts about the remote.  The words
+// the pattern matched are replaced by those of the replacement, and
+// the arguments after them are kept as they were given.
+func rewriteCommand(login string, command []string) []string {
+	if *noRewrite {
+		return command
+	}
+	rules, err := readRewriteRules()
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	for _, r := range rules {
+		if !r.matches(login, command) {
+			continue
+		}
+		repl := r.replacement
+		if strings.Contains(repl, "{nproc}") || strings.Contains(repl, "{memory}") {
+			nproc, memory, err := remoteFacts(login)
+			if err != nil {
+				explainf("not rewriting the command, as the remote's facts are unknown: %v", err)
+				return command
+			}
+			repl = strings.NewReplacer("{nproc}", nproc, "{memory}", memory).Replace(repl)
+		}
+		rewritten := append(strings.Fields(repl), command[len(r.pattern):]...)
+		explainf("rewriting the command to %s by the rule for %q in %s", quoteArgs(rewritten), strings.Join(r.pattern, " "), rewriteFile())
+		return rewritten
+	}
+	return command
+}
+
+// Returns the number of processors and GiB of memory on login.
+func remoteFacts(login string) (nproc string, memory string, err error) {
+	out, err := remoteOutput(login, `nproc; awk '/^MemTotal:/ { print int($2 / 1048576) }' /proc/meminfo`)
+	if err != nil {
+		return "", "", err
+	}
+	f := strings.Fields(string(out))
+	if len(f) != 2 {
+		return "", "", fmt.Errorf("unexpected output %q", out)
+	}
+	return f[0], f[1], nil
+}
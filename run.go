@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flag ...] [run] command [argument ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flag ...] shell\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flag ...] subcommand [argument ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "subcommands: %s\n", strings.Join(subcommandNames(), " "))
+		flag.PrintDefaults()
+	}
+}
+
+// Subcommands making up a run of a command on the remote rather than
+// standing apart from it: run takes the command, so that it is never
+// mistaken for a subcommand, and shell runs the remote user's login
+// shell.  Both take cpu's flags after their name too.
+var runSubcommands = []string{"run", "shell"}
+
+// Set when the command was given after run or shell, so that it is
+// run as it is.
+var explicitRun bool
+
+// Returns the command given after the flags, or after run or shell
+// and the flags following those.
+func parseRunSubcommand(args []string) []string {
+	if len(args) == 0 || !contains(runSubcommands, args[0]) {
+		return args
+	}
+	name := args[0]
+	flag.CommandLine.Parse(args[1:])
+	explicitRun = true
+	if name == "shell" {
+		if flag.NArg() > 0 {
+			exit(EX_USAGE, "shell takes no command")
+		}
+		return []string{`exec "$SHELL" -l`}
+	}
+	return flag.Args()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+var (
+	scope           = flag.Bool("scope", false, "run the command in a transient systemd scope on the remote")
+	scopeProperties stringList
+)
+
+func init() {
+	flag.Var(&scopeProperties, "scope-property",
+		"set a `property` such as MemoryMax=8G on the systemd scope, implying -scope; may be repeated")
+}
+
+// Name of the scope unit, unique to the remote shell.
+const scopeUnit = "cpu-$$.scope"
+
+// Runs the command in a transient scope of the user's systemd
+// instance, so that resource limits apply to every process it starts
+// and the whole tree can be stopped together when the session ends.
+func makeScopePrefix() string {
+	if !*scope && len(scopeProperties) == 0 {
+		return ""
+	}
+	explainf("running the command in the systemd scope %s with properties %s",
+		scopeUnit, strings.Join(scopeProperties, ", "))
+	prefix := "systemd-run --user --scope --quiet --collect --unit=" + scopeUnit + " "
+	for _, p := range scopeProperties {
+		prefix += fmt.Sprintf("-p %s ", rcpu.Quote(p))
+	}
+	return prefix
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	scratch = flag.Bool("scratch", false,
+		"copy the working directory to a fresh remote directory and run there")
+	keep = flag.Bool("keep", false,
+		"keep the -scratch directory on the remote after running")
+)
+
+// Parent of all scratch directories on the remote.
+const scratchRoot = cacheRoot + "/scratch"
+
+// Creates a uniquely named scratch directory on login and returns its path.
+func makeScratchDir(login string) (string, error) {
+	enforceQuota(login)
+	mk := fmt.Sprintf(`mkdir -p %s && d=$(mktemp -d %s/XXXXXXXX) && %s && echo "$d"`,
+		scratchRoot, scratchRoot, makeLockCmd)
+	out, err := remoteOutput(login, mk)
+	if err != nil {
+		return "", fmt.Errorf("creating scratch directory on %s: %v", login, err)
+	}
+	dir := strings.TrimSpace(string(out))
+	lockedDirs = append(lockedDirs, dir)
+	return dir, nil
+}
+
+func removeScratchDir(login string, dir string) error {
+	if _, err := remoteOutput(login, "rm -rf "+dir+" "+lockFile(dir)); err != nil {
+		return fmt.Errorf("removing %s:%s: %v", login, dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	logFile  = flag.String("log", "", "append the remote output to `file`, each line with a timestamp")
+	logInput = flag.Bool("log-input", false, "also log the input sent to the remote with -log")
+)
+
+// sessionLog is a transcript of a session with a timestamp on each
+// line, in the manner of script(1).
+type sessionLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	watchers []*lineWatcher
+}
+
+func openSessionLog(name string) (*sessionLog, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionLog{f: f}, nil
+}
+
+func (l *sessionLog) line(stream string, line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.f, "%s %s %s\n", time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
+		stream, bytes.TrimRight(line, "\r"))
+}
+
+// Returns a writer copying to w that logs each line as part of stream.
+func (l *sessionLog) watch(w io.Writer, stream string) io.Writer {
+	lw := &lineWatcher{w: w, fn: func(line []byte) { l.line(stream, line) }}
+	l.watchers = append(l.watchers, lw)
+	return lw
+}
+
+// Returns a reader of r that logs each line read as input.
+func (l *sessionLog) input(r io.Reader) io.Reader {
+	return io.TeeReader(r, l.watch(io.Discard, "in "))
+}
+
+// Logs any unterminated last lines and closes the log.
+func (l *sessionLog) close() error {
+	for _, lw := range l.watchers {
+		if len(lw.buf) > 0 {
+			lw.fn(lw.buf)
+		}
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Directory on the remote holding the sockets of shared sessions.  It
+// cannot be listed, so a session is only found by its token.
+const shareDir = "/tmp/cpu-share"
+
+// Makes shareDir unless it exists, and refuses to share if it is not
+// the sticky directory only its owner can list, owned by root or the
+// user, as another user could have made it to take over the sockets.
+const checkShareDir = `mkdir -m 1733 $d 2>/dev/null; set -- $(ls -ldn $d); ` +
+	`case $1 in drwx-wx-wt*) ;; *) false;; esac && { [ "$3" = "$(id -u)" ] || [ "$3" = 0 ]; } || ` +
+	`{ echo "cpu: $d is not a private share directory" >&2; exit 77; }; `
+
+// Refuses to share with a tmux older than 3.3, which has no
+// server-access to keep the session to the users named and read-only
+// for them.  The token is in the process list along with the socket,
+// so it is these permissions that protect the session.
+const checkShareTmux = `tmux -V | awk '{ split($2, v, "."); exit !(v[1] > 3 || v[1] == 3 && v[2] + 0 >= 3) }' || ` +
+	`{ echo "cpu: sharing needs tmux 3.3 or later" >&2; exit 69; }; `
+
+var shareToken = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// share starts a tmux(1) session on the remote that teammates with an
+// account there can attach to with the printed token.  The session is
+// read-only for them unless -rw is given, which tmux 3.3 and later
+// enforce for the users named, and sharing is refused with older
+// ones.  Sharing stops when the session ends or is detached from.
+func share(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	rw := fs.Bool("rw", false, "let teammates type into the session")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s share [-rw] [user ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	token := newSessionID()
+	access := "-a"
+	if !*rw {
+		access += " -r"
+	}
+	var allow strings.Builder
+	for _, u := range fs.Args() {
+		fmt.Fprintf(&allow, "tmux -S $s server-access %s %s && ", access, rcpu.Quote(u))
+	}
+	cmd := fmt.Sprintf(`d=%s; %s%ss=$d/%s; `+
+		`tmux -S $s new-session -d -s share && chmod 777 $s && %stmux -S $s attach; `+
+		`tmux -S $s kill-server 2>/dev/null; rm -f $s`, shareDir, checkShareTmux, checkShareDir, token, allow.String())
+
+	hint := "attach -r"
+	if *rw {
+		hint = "attach"
+	}
+	fmt.Fprintf(os.Stderr, "%s: teammates attach with: cpu -r %s %s %s\n", os.Args[0], login, hint, token)
+	return runRemote(login, path, []string{cmd})
+}
+
+// attach joins a session shared on the remote with cpu share.
+func attach(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	readOnly := fs.Bool("r", false, "only watch the session")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s attach [-r] token\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || !shareToken.MatchString(fs.Arg(0)) {
+		fs.Usage()
+		return EX_USAGE
+	}
+
+	cmd := fmt.Sprintf("tmux -S %s/%s attach", shareDir, fs.Arg(0))
+	if *readOnly {
+		cmd += " -r"
+	}
+	return runRemote(login, path, []string{cmd})
+}
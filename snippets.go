@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var snippetsFile = flag.String("snippets", ".cpusnippets",
+	"type the text bound to a function key in `file` when the key is pressed in an interactive session")
+
+// Escape sequences xterm and most terminals send for the function keys.
+var functionKeys = map[string]string{
+	"F1": "\x1bOP", "F2": "\x1bOQ", "F3": "\x1bOR", "F4": "\x1bOS",
+	"F5": "\x1b[15~", "F6": "\x1b[17~", "F7": "\x1b[18~", "F8": "\x1b[19~",
+	"F9": "\x1b[20~", "F10": "\x1b[21~", "F11": "\x1b[23~", "F12": "\x1b[24~",
+}
+
+// Whether the local terminal is in raw mode for typing snippets, so
+// the remote terminal is told the size ssh cannot find out.
+var typingSnippets bool
+
+// Reads the snippets of the project, one per line:
+//
+//	key text
+//
+// where key is F1 to F12.  The text is typed as it is, or interpreted
+// as a Go string literal if it is one, so that "make test\r" also
+// presses Enter.
+func readSnippets(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	snippets := map[string]string{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, text, _ := strings.Cut(line, " ")
+		seq, ok := functionKeys[strings.ToUpper(key)]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown key %q", name, n, key)
+		}
+		text = strings.TrimSpace(text)
+		if strings.HasPrefix(text, `"`) {
+			if text, err = strconv.Unquote(text); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+		}
+		snippets[seq] = text
+	}
+	return snippets, s.Err()
+}
+
+// snippetReader types the snippet bound to a key in place of the
+// key's escape sequence.  Terminals send a key's sequence in one
+// write, so sequences are only recognised within a single read.
+type snippetReader struct {
+	r        io.Reader
+	snippets map[string]string
+	buf      []byte
+}
+
+func (sr *snippetReader) Read(p []byte) (int, error) {
+	if len(sr.buf) == 0 {
+		n, err := sr.r.Read(p)
+		if n == 0 {
+			return 0, err
+		}
+		sr.buf = sr.expand(p[:n])
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *snippetReader) expand(in []byte) []byte {
+	if bytes.IndexByte(in, 0x1b) < 0 {
+		return append([]byte(nil), in...)
+	}
+	var out []byte
+	for len(in) > 0 {
+		matched := false
+		for seq, text := range sr.snippets {
+			if bytes.HasPrefix(in, []byte(seq)) {
+				out = append(out, text...)
+				in = in[len(seq):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, in[0])
+			in = in[1:]
+		}
+	}
+	return out
+}
+
+// Returns stdin with the project's snippets typed in place of their
+// keys, if there are any and stdin is a terminal.  The terminal is put
+// in raw mode until restore is called, as ssh would otherwise do.
+func typeSnippets(stdin io.Reader) (r io.Reader, restore func()) {
+	snippets, err := readSnippets(*snippetsFile)
+	if err != nil {
+		exit(EX_DATAERR, "%v", err)
+	}
+	if len(snippets) == 0 || stdin != io.Reader(os.Stdin) || !isatty(os.Stdin) {
+		return stdin, func() {}
+	}
+	restore, err = makeRaw(os.Stdin)
+	if err != nil {
+		explainf("not typing the snippets in %s: %v", *snippetsFile, err)
+		return stdin, func() {}
+	}
+	explainf("typing the %d snippets in %s when their keys are pressed", len(snippets), *snippetsFile)
+	typingSnippets = true
+	return &snippetReader{r: stdin, snippets: snippets}, restore
+}
+
+// Sets the size of the remote terminal, which ssh takes from its
+// standard input and so misses while snippets are typed.
+func makeTerminalSizeCmd() string {
+	if !typingSnippets {
+		return ""
+	}
+	width, height := terminalSize(os.Stdout)
+	return fmt.Sprintf("stty rows %d cols %d 2>/dev/null; ", height, width)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Configurations already asked of ssh -G, by login.
+var (
+	configMu    sync.Mutex
+	configCache = map[string]map[string]string{}
+)
+
+// Returns the configuration ssh(1) would use for login, as reported
+// by ssh -G, with lowercase keys.  ssh is only asked once for each
+// login.
+func effectiveConfig(login string) map[string]string {
+	configMu.Lock()
+	conf, ok := configCache[login]
+	configMu.Unlock()
+	if !ok {
+		conf = readEffectiveConfig(login)
+		configMu.Lock()
+		configCache[login] = conf
+		configMu.Unlock()
+	}
+	return conf
+}
+
+func readEffectiveConfig(login string) map[string]string {
+	args := append(makeSshOptions(), "-G", login)
+	out, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		return nil
+	}
+
+	conf := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), " ", 2)
+		if len(kv) == 2 {
+			if _, ok := conf[kv[0]]; !ok {
+				conf[kv[0]] = kv[1]
+			}
+		}
+	}
+	return conf
+}
+
+// Path of the user's ssh_config(5).
+func userSshConfig() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// Lists the patterns of the Host lines in the user's ssh_config(5) and
+// the files it includes, in order.
+func sshConfigPatterns() []string {
+	return readSshConfigPatterns(userSshConfig(), 0)
+}
+
+// Reads the Host patterns of one configuration file, following its
+// Include directives to the depth ssh(1) allows.
+func readSshConfigPatterns(name string, depth int) []string {
+	if depth > 16 {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if k, v, ok := strings.Cut(line, "="); ok && !strings.ContainsAny(k, " \t") {
+			line = k + " " + v
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "host":
+			patterns = append(patterns, fields[1:]...)
+		case "include":
+			for _, inc := range fields[1:] {
+				if strings.HasPrefix(inc, "~/") {
+					home, _ := os.UserHomeDir()
+					inc = filepath.Join(home, inc[2:])
+				} else if !filepath.IsAbs(inc) {
+					// relative to ~/.ssh, as in the user's configuration
+					inc = filepath.Join(filepath.Dir(userSshConfig()), inc)
+				}
+				matches, _ := filepath.Glob(inc)
+				for _, m := range matches {
+					patterns = append(patterns, readSshConfigPatterns(m, depth+1)...)
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// Lists the host aliases declared in the user's ssh_config(5).
+func knownHosts() []string {
+	var hosts []string
+	for _, p := range sshConfigPatterns() {
+		if !strings.ContainsAny(p, "*?!") {
+			hosts = append(hosts, p)
+		}
+	}
+	return hosts
+}
+
+// Once ssh(1) has failed to connect, explains a remote whose host is
+// not known to DNS under the name ssh resolves it to, suggesting
+// configured hosts of a similar name, as ssh has little to say about
+// it.  Hosts reached through a proxy are left to ssh, as they need not
+// be known here.
+func checkHost(login string) {
+	conf := effectiveConfig(login)
+	if conf == nil {
+		return
+	}
+	if pj, pc := conf["proxyjump"], conf["proxycommand"]; pj != "" && pj != "none" || pc != "" && pc != "none" {
+		return
+	}
+	host := conf["hostname"]
+	if net.ParseIP(host) != nil {
+		return
+	}
+	_, err := net.LookupHost(host)
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		return
+	}
+	explainf("%s resolves to %s in ssh's configuration, which is not known to DNS", hostOf(login), host)
+	msg := fmt.Sprintf("unknown host %s", hostOf(login))
+	if matches := closeMatches(hostOf(login), knownHosts()); len(matches) > 0 {
+		msg += fmt.Sprintf(", did you mean: %s?", strings.Join(matches, ", "))
+	}
+	exit(EX_NOHOST, "%s", msg)
+}
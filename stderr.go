@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Parent of the named pipes carrying standard error past the remote
+// pseudo-terminal.
+const stderrRoot = cacheRoot + "/stderr"
+
+// Named pipe on the remote the command's standard error is redirected
+// to, or "" if it shares the pseudo-terminal with standard output.
+var stderrPipe string
+
+// Reports whether standard error has to be kept apart from the remote
+// pseudo-terminal, which would merge it into standard output.  This is
+// the case when there is a pseudo-terminal but standard error has been
+// redirected locally, as in cpu make 2>errors.txt.
+func needStderrPipe() bool {
+	return wantTTY() && !isatty(os.Stderr) && !windowsRemote()
+}
+
+// Makes a named pipe on login for the command's standard error and
+// starts copying from it to w over a session without a terminal.  The
+// returned function waits for the copy to finish.
+func pipeStderr(login string, w io.Writer) (func(), error) {
+	mk := fmt.Sprintf(`mkdir -p %s && d=$(mktemp -d %s/XXXXXXXX) && mkfifo "$d/pipe" && echo "$d/pipe"`,
+		stderrRoot, stderrRoot)
+	out, err := remoteOutput(login, mk)
+	if err != nil {
+		return nil, fmt.Errorf("creating pipe for stderr on %s: %v", login, err)
+	}
+	stderrPipe = strings.TrimSpace(string(out))
+	explainf("standard error is not a terminal, so passing it through %s instead of the pseudo-terminal", stderrPipe)
+
+	args := append(makeSshOptions(), "-T", login, fmt.Sprintf(`p=%s; cat "$p"; rm -r "${p%%/*}"`, stderrPipe))
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdout = w
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return func() {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			// the command never opened the pipe
+			cmd.Process.Kill()
+			<-done
+			remoteOutput(login, fmt.Sprintf(`p=%s; rm -r "${p%%/*}"`, stderrPipe))
+		}
+	}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var sudo = flag.Bool("sudo", false,
+	"run the command with sudo on the remote, asking for the password locally")
+
+// Parent of the per-run directories holding the askpass helper and
+// the pipe the password is passed through.
+const sudoRoot = cacheRoot + "/sudo"
+
+// Directory on the remote for this run's askpass helper, if -sudo is given.
+var sudoDir string
+
+// Asks for the remote user's password on the local terminal, where it
+// is not mangled by the shell wrapper or the remote pseudo-terminal.
+func readPassword(login string) ([]byte, error) {
+	tty, err := os.Open(consoleName)
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	if err := setEcho(tty, false); err != nil {
+		return nil, err
+	}
+	defer setEcho(tty, true)
+	fmt.Fprintf(os.Stderr, "[sudo] password for %s: ", login)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	return []byte(strings.TrimRight(line, "\r\n")), err
+}
+
+// Prepares an askpass helper on login that reads the password from a
+// named pipe exactly once, and starts writing the password into the
+// pipe over a separate session.  No password is written to disk or
+// passed on a command line.  The returned function cancels the writer
+// and removes the helper.
+func relayPassword(login string) (func(), error) {
+	password, err := readPassword(login)
+	if err != nil {
+		return nil, fmt.Errorf("reading password: %v", err)
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	sudoDir = sudoRoot + "/" + hex.EncodeToString(id[:])
+
+	setup := fmt.Sprintf(`umask 077 && mkdir -p %s && mkfifo "$d/pipe" && `+
+		`printf '#!/bin/sh\n[ -p "%%s" ] && cat "%%s" && rm -f "%%s"\n' "$d/pipe" "$d/pipe" "$d/pipe" > "$d/askpass" && `+
+		`chmod 700 "$d/askpass"`, sudoDir)
+	if _, err := remoteOutput(login, "d="+sudoDir+"; "+setup); err != nil {
+		return nil, fmt.Errorf("preparing sudo on %s: %v", login, err)
+	}
+
+	args := append(makeSshOptions(), "-T", login, fmt.Sprintf("cat > %s/pipe", sudoDir))
+	writer := exec.Command("ssh", args...)
+	writer.Stdin = strings.NewReader(string(password) + "\n")
+	if err := writer.Start(); err != nil {
+		return nil, err
+	}
+	explainf("relaying the sudo password to %s through %s/pipe", login, sudoDir)
+	return func() {
+		writer.Process.Kill()
+		writer.Wait()
+		remoteOutput(login, "rm -rf "+sudoDir)
+	}, nil
+}
+
+// Runs the command with sudo, which asks the askpass helper for the password.
+func makeSudoPrefix() string {
+	if sudoDir == "" {
+		return ""
+	}
+	explainf("running the command with sudo")
+	return fmt.Sprintf("env SUDO_ASKPASS=%s/askpass sudo -A ", sudoDir)
+}
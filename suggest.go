@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Exit status of ssh(1) when the connection could not be made.
+const sshFailure = 255
+
+// Tells the user about close matches for a remote command or host
+// that could not be found, judging by the exit status of ssh(1).
+func suggest(code int, login string, command []string) {
+	var word string
+	var candidates []string
+	switch code {
+	case EX_CMDNFOUND:
+		if f := strings.Fields(command[0]); len(f) > 0 {
+			word = f[0]
+		}
+		if word == "" || strings.Contains(word, "/") {
+			return
+		}
+		candidates = remoteCommands(login)
+	case sshFailure:
+		word = login[strings.LastIndex(login, "@")+1:]
+		candidates = knownHosts()
+		for _, host := range candidates {
+			if host == word {
+				return
+			}
+		}
+	default:
+		return
+	}
+
+	if matches := closeMatches(word, candidates); len(matches) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s not found, did you mean: %s?\n",
+			os.Args[0], word, strings.Join(matches, ", "))
+	}
+}
+
+// Lists the executables in the remote PATH as seen by the remote shell.
+func remoteCommands(login string) []string {
+	// must survive expansion by both the login shell and the wrapper
+	list := `ls $(echo $PATH | tr : ' ') 2>/dev/null`
+	out, _ := remoteOutput(login, makeShellWrapper(*shell, list))
+
+	var cmds []string
+	for _, name := range strings.Fields(string(out)) {
+		// skip the directory headings
+		if !strings.Contains(name, "/") {
+			cmds = append(cmds, name)
+		}
+	}
+	return cmds
+}
+
+// Returns up to three candidates within a small edit distance of word,
+// closest first.
+func closeMatches(word string, candidates []string) []string {
+	max := len(word)/3 + 1
+	seen := make(map[string]bool)
+	var matches []string
+	dist := make(map[string]int)
+	for _, c := range candidates {
+		if seen[c] || c == word {
+			continue
+		}
+		seen[c] = true
+		if d := levenshtein(word, c); d <= max {
+			matches = append(matches, c)
+			dist[c] = d
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if dist[matches[i]] != dist[matches[j]] {
+			return dist[matches[i]] < dist[matches[j]]
+		}
+		return matches[i] < matches[j]
+	})
+	if len(matches) > 3 {
+		matches = matches[:3]
+	}
+	return matches
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
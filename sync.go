@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+var bwlimit = flag.String("bwlimit", os.Getenv("CPU_BWLIMIT"),
+	"cap file transfers at this `rate` per second, such as 5M")
+
+// Returns the -bwlimit rate in KiB per second, or 0 for no limit.
+func bandwidthLimit() int64 {
+	if *bwlimit == "" {
+		return 0
+	}
+	kib, err := parseSize(*bwlimit)
+	if err != nil || kib <= 0 {
+		exit(EX_USAGE, "invalid -bwlimit %q", *bwlimit)
+	}
+	return kib
+}
+
+// stringList is a flag.Value collecting every occurrence of a flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// Transfers the contents of the local directory src to path on login,
+// creating the remote directory if it does not exist.
+func push(src string, login string, path string) error {
+	path = rcpu.MapPath(path)
+	args := []string{
+		"--filter=:- .gitignore",
+		"--exclude=/.git",
+		fmt.Sprintf("--rsync-path=mkdir -p %s && rsync", path),
+		src + "/",
+		rcpu.HostPath(login, path+"/"),
+	}
+	return rsync(args...)
+}
+
+// Fetches the files under path on login matching any of globs into
+// the local directory dst.  Globs are relative to path.
+func fetch(login string, path string, dst string, globs []string) error {
+	path = rcpu.MapPath(path)
+	args := []string{"--prune-empty-dirs", "--include=*/"}
+	for _, glob := range globs {
+		args = append(args, "--include=/"+strings.TrimPrefix(glob, "/"))
+	}
+	args = append(args, "--exclude=*",
+		rcpu.HostPath(login, path+"/"),
+		dst+"/")
+	return rsync(args...)
+}
+
+func rsync(args ...string) error {
+	shell := strings.Join(append([]string{"ssh"}, makeSshOptions()...), " ")
+	args = append([]string{"-az", "-e", shell}, args...)
+	if kib := bandwidthLimit(); kib > 0 {
+		args = append([]string{fmt.Sprintf("--bwlimit=%d", kib)}, args...)
+	}
+	if verbose >= logCommands {
+		args = append([]string{"-v"}, args...)
+	}
+
+	cmd := exec.Command("rsync", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if verbose >= logCommands {
+		log.Println(cmd)
+	}
+	bugReport.exec(cmd.Args)
+	return cmd.Run()
+}
+
+// syncTree pushes the working directory to the remote as -sync does,
+// without running a command, so that it can be kept up to date ahead
+// of the commands run there.
+func syncTree(login string, path string, args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s sync\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return EX_USAGE
+	}
+	cwd, _ := os.Getwd()
+	explainf("pushing %s to %s:%s with rsync", cwd, login, path)
+	if err := push(cwd, login, path); err != nil {
+		exit(EX_SYNC, "push: %v", err)
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sny.no/cpu/rcpu"
+)
+
+var stdinJSON = flag.String("stdin-json", "",
+	"send this local JSON file to the standard input of the remote command")
+
+// Marks the start of a placeholder replaced by the output of a local
+// command, as in {local:git rev-parse HEAD}.
+const localPlaceholder = "{local:"
+
+// Replaces {local:...} placeholders in args by the output of running
+// their contents with the local sh(1), quoted for the remote shell.
+// Braces may nest inside a placeholder.
+func expandLocal(args []string) ([]string, error) {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		var b strings.Builder
+		for {
+			start := strings.Index(arg, localPlaceholder)
+			if start < 0 {
+				b.WriteString(arg)
+				break
+			}
+			end, depth := -1, 0
+			for j := start + 1; j < len(arg); j++ {
+				if arg[j] == '{' {
+					depth++
+				} else if arg[j] == '}' {
+					if depth == 0 {
+						end = j
+						break
+					}
+					depth--
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated placeholder in %q", arg)
+			}
+
+			script := arg[start+len(localPlaceholder) : end]
+			explainf("running %q locally for its output", script)
+			out, err := exec.Command("sh", "-c", script).Output()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", script, err)
+			}
+			b.WriteString(arg[:start])
+			b.WriteString(rcpu.Quote(strings.TrimRight(string(out), "\n")))
+			arg = arg[end+1:]
+		}
+		expanded[i] = b.String()
+	}
+	return expanded, nil
+}
+
+// Placeholders in the path of a remote, as in builder:~/src/{repo}.
+var pathPlaceholder = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// Expands the placeholders in path from the local git checkout and
+// environment: {repo} is the name of the checkout's top directory,
+// {branch} its current branch, {user} the local user, and {cwd_rel}
+// the working directory relative to the top of the checkout.
+func expandPath(path string) (string, error) {
+	var err error
+	expanded := pathPlaceholder.ReplaceAllStringFunc(path, func(p string) string {
+		var v string
+		var e error
+		switch p {
+		case "{repo}":
+			v, e = gitOutput("rev-parse", "--show-toplevel")
+			v = filepath.Base(v)
+		case "{branch}":
+			v, e = gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+		case "{cwd_rel}":
+			var top string
+			if top, e = gitOutput("rev-parse", "--show-toplevel"); e == nil {
+				cwd, _ := os.Getwd()
+				v, e = filepath.Rel(top, cwd)
+				v = filepath.ToSlash(v)
+			}
+		case "{user}":
+			v = os.Getenv("USER")
+			if usr, e := user.Current(); e == nil {
+				v = usr.Username
+			}
+		default:
+			e = fmt.Errorf("unknown placeholder %s", p)
+		}
+		if e != nil && err == nil {
+			err = fmt.Errorf("expanding %s in %q: %v", p, path, e)
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// Runs git(1) in the working directory and returns its output.
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Opens the file given by -stdin-json after checking that it holds
+// valid JSON.
+func openStdinJSON() (*os.File, error) {
+	data, err := os.ReadFile(*stdinJSON)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%s: not valid JSON", *stdinJSON)
+	}
+	return os.Open(*stdinJSON)
+}
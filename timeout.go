@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+var timeout = flag.Duration("timeout", 0,
+	"kill the remote command and its processes after this `duration`, exiting with status 124")
+
+const (
+	// Exit status when -timeout is exceeded, as with timeout(1).
+	EX_TIMEOUT = 124
+
+	// How long the command has to exit after being terminated before
+	// it is killed.
+	timeoutGrace = 10 * time.Second
+)
+
+// Runs the command under timeout(1), which hangs up its whole process
+// group when the time is up, since interactive shells ignore SIGTERM.
+// With a pseudo-terminal the command has to stay in the foreground
+// process group to read from it, so only the command itself is
+// terminated.
+func makeTimeoutPrefix() string {
+	if *timeout <= 0 {
+		return ""
+	}
+	secs := int64((*timeout + time.Second - 1) / time.Second)
+	explainf("terminating the command on the remote after %ds", secs)
+	foreground := ""
+	if wantTTY() {
+		foreground = "--foreground "
+	}
+	return fmt.Sprintf("timeout %s-s HUP -k %ds %ds ", foreground, int64(timeoutGrace/time.Second), secs)
+}
+
+// Kills the local ssh(1) if the remote has not given up some time
+// after -timeout, as it will not if the connection hangs, and returns
+// a function reporting whether that happened.
+func watchTimeout(cmd *exec.Cmd) func() bool {
+	if *timeout <= 0 {
+		return func() bool { return false }
+	}
+	fired := make(chan struct{})
+	t := time.AfterFunc(*timeout+2*timeoutGrace, func() {
+		fmt.Fprintf(os.Stderr, "%s: timed out after %v\n", os.Args[0], *timeout)
+		close(fired)
+		cmd.Process.Kill()
+	})
+	return func() bool {
+		t.Stop()
+		select {
+		case <-fired:
+			return true
+		default:
+			return false
+		}
+	}
+}
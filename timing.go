@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var timing = flag.Bool("timing", false,
+	"report how long each phase took, such as connecting, syncing, and the command itself")
+
+// phase is a stage of a run and how long it took.
+type phase struct {
+	name string
+	took time.Duration
+}
+
+var (
+	phases []phase
+
+	// When the last phase ended.
+	phaseEnd = startTime
+)
+
+// Notes that the phase of the given name ended just now.
+func endPhase(name string) {
+	now := time.Now()
+	phases = append(phases, phase{name, now.Sub(phaseEnd)})
+	phaseEnd = now
+}
+
+// Reports the phases and their share of the run with -timing.
+func printTiming() {
+	if !*timing {
+		return
+	}
+	total := time.Since(startTime)
+	tw := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(os.Stderr, "%s: timing\n", os.Args[0])
+	for _, p := range phases {
+		fmt.Fprintf(tw, "%s\t%v\t%.0f%%\t\n", p.name, p.took.Round(time.Millisecond), 100*p.took.Seconds()/total.Seconds())
+	}
+	fmt.Fprintf(tw, "total\t%v\t\t\n", total.Round(time.Millisecond))
+	tw.Flush()
+}
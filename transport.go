@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// Remote commands git(1) runs over its ssh transport.
+var gitCommand = regexp.MustCompile(`^git[ -](upload-pack|receive-pack|upload-archive|lfs-authenticate|lfs-transfer) `)
+
+// Reports whether cpu was started by git(1) as GIT_SSH_COMMAND,
+// judging by the remote command it was asked to run.
+func gitMode() bool {
+	return len(os.Args) > 2 && gitCommand.MatchString(os.Args[len(os.Args)-1])
+}
+
+// Reports whether cpu was asked to provide only the connection, as
+// when it is used as the remote shell of rsync(1).
+func transportMode() bool {
+	return firstArg("transport-only")
+}
+
+// Runs command on login as a plain ssh(1) session, without mapping
+// the working directory or wrapping it in a shell, for programs that
+// only need cpu for the connection.
+func transport(login string, command []string) int {
+	args := append(makeSshOptions(), "-T", "-e", "none", login)
+	cmd := exec.Command("ssh", append(args, command...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if verbose >= logCommands {
+		log.Println(cmd)
+	}
+
+	if err := cmd.Run(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return exiterr.ExitCode()
+		}
+		exit(EX_CMDNFOUND, "%v", err)
+	}
+	return 0
+}
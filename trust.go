@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+var trust = flag.String("trust", "normal",
+	"how far the remote is `trusted`: normal, or low to give it no way back into this machine")
+
+// Flags giving the remote a way back into this machine, letting it
+// write files into the working directory, or acting locally on what it
+// prints, which are refused for remotes of low trust.
+var reverseFlags = []string{"A", "X", "R", "mount", "sudo", "watch", "pull", "autoforward", "rewrite-urls"}
+
+func lowTrust() bool {
+	return *trust == "low"
+}
+
+// Checks -trust, and for remotes of low trust refuses the flags that
+// would expose this machine to them.  It is meant to be set in the
+// profile of a remote that is shared or otherwise less trusted.
+func checkTrust() {
+	switch *trust {
+	case "normal":
+		return
+	case "low":
+	default:
+		exit(EX_USAGE, "-trust must be normal or low")
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if contains(reverseFlags, f.Name) && f.Value.String() != "false" {
+			exit(EX_USAGE, "-%s is refused for a remote of low trust", f.Name)
+		}
+	})
+	*agent, *noAgent = false, true
+	explainf("the remote is of low trust, so forwarding neither the agent, X11 nor remote ports, and keeping back variables that look secret")
+}
+
+// Options keeping ssh(1) from forwarding what ssh_config(5) may ask it
+// to for a remote of low trust.  They are given with -o, as scp(1) is
+// passed them too, and go first, as ssh uses the first value of an
+// option it is given.  Forwardings are cleared altogether unless local
+// ones are asked for, which ssh would clear as well.
+func makeTrustOptions() []string {
+	if !lowTrust() {
+		return nil
+	}
+	opts := []string{"-o", "ForwardAgent=no", "-o", "ForwardX11=no"}
+	if len(localForwards) == 0 {
+		opts = append(opts, "-o", "ClearAllForwardings=yes")
+	}
+	return opts
+}
+
+// Drops the variables that look like secrets from those forwarded to
+// a remote of low trust.
+func trustedVars(env []string) []string {
+	if !lowTrust() {
+		return env
+	}
+	var kept []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !secretName.MatchString(name) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,77 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Terminal the user can be asked questions on, even with the standard
+// streams redirected.
+const consoleName = "/dev/tty"
+
+func isatty(fd *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+		ioctlReadTermios, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// Returns the width and height of the terminal fd, or 80x24 if fd is
+// not a terminal.
+func terminalSize(fd *os.File) (int, int) {
+	var ws struct{ row, col, xpixel, ypixel uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+		syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.col == 0 {
+		return 80, 24
+	}
+	return int(ws.col), int(ws.row)
+}
+
+// Turns echoing of input on the terminal fd on or off.
+func setEcho(fd *os.File, on bool) error {
+	var termios syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+		ioctlReadTermios, uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		return errno
+	}
+	if on {
+		termios.Lflag |= syscall.ECHO
+	} else {
+		termios.Lflag &^= syscall.ECHO
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+		ioctlWriteTermios, uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Puts the terminal fd in raw mode, as ssh(1) does with a terminal on
+// its standard input, and returns a function restoring the old mode.
+func makeRaw(fd *os.File) (restore func(), err error) {
+	var old syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+		ioctlReadTermios, uintptr(unsafe.Pointer(&old))); errno != 0 {
+		return nil, errno
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+		ioctlWriteTermios, uintptr(unsafe.Pointer(&raw))); errno != 0 {
+		return nil, errno
+	}
+	return func() {
+		syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(),
+			ioctlWriteTermios, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Console the user can be asked questions on, even with the standard
+// streams redirected.
+const consoleName = "CONIN$"
+
+// Reports whether fd is a console.  ssh.exe attaches the console to
+// the remote pseudo-terminal itself, so this is all cpu needs to know.
+func isatty(fd *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd.Fd()), &mode) == nil
+}
+
+var getConsoleScreenBufferInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleScreenBufferInfo")
+
+// Returns the width and height of the console window of fd, or 80x24
+// if fd is not a console.
+func terminalSize(fd *os.File) (int, int) {
+	var info struct {
+		size, cursor             [2]int16
+		attributes               uint16
+		left, top, right, bottom int16
+		maxSize                  [2]int16
+	}
+	if r, _, _ := getConsoleScreenBufferInfo.Call(fd.Fd(), uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 80, 24
+	}
+	return int(info.right-info.left) + 1, int(info.bottom-info.top) + 1
+}
+
+const enableEchoInput = 0x4
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// Turns echoing of input on the console fd on or off.
+func setEcho(fd *os.File, on bool) error {
+	var mode uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(fd.Fd()), &mode); err != nil {
+		return err
+	}
+	if on {
+		mode |= enableEchoInput
+	} else {
+		mode &^= enableEchoInput
+	}
+	if r, _, err := setConsoleMode.Call(fd.Fd(), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Raw mode is left to ssh.exe, which reads the console itself.
+func makeRaw(fd *os.File) (restore func(), err error) {
+	return nil, errors.New("raw mode is not supported on Windows")
+}
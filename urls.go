@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+var rewriteURLs = flag.Bool("rewrite-urls", false,
+	"rewrite URLs of forwarded ports in the output to their local address")
+
+// URLs of servers listening on the remote, which are reached through
+// forwards on the local system rather than at these addresses.
+var remoteURL = regexp.MustCompile(`\b(https?://)(?:localhost|127\.0\.0\.1|0\.0\.0\.0|\[::1?\]):(\d{1,5})\b`)
+
+// urlRewriter passes output through to w with the URLs of forwarded
+// ports replaced by their local address.  URLs are only recognised
+// within a single write, which servers announcing them make.
+type urlRewriter struct {
+	w io.Writer
+	f *portForwarder
+}
+
+// Returns the local port the remote port is forwarded from by -L or,
+// if f is not nil, by -autoforward, which forwards it first if it has
+// not yet seen it announced.
+func (u *urlRewriter) localPort(port int) (int, bool) {
+	for _, spec := range localForwards {
+		_, local, target := splitLocalForward(spec)
+		host, hostPort, err := net.SplitHostPort(target)
+		if err != nil || hostPort != strconv.Itoa(port) {
+			continue
+		}
+		if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			if n, err := strconv.Atoi(local); err == nil {
+				return mappedPort(n), true
+			}
+		}
+	}
+	if u.f != nil {
+		u.f.forward(port)
+		return mappedPort(port), true
+	}
+	return 0, false
+}
+
+func (u *urlRewriter) Write(p []byte) (int, error) {
+	out := remoteURL.ReplaceAllFunc(p, func(url []byte) []byte {
+		m := remoteURL.FindSubmatch(url)
+		port, err := strconv.Atoi(string(m[2]))
+		if err != nil {
+			return url
+		}
+		local, ok := u.localPort(port)
+		if !ok {
+			return url
+		}
+		return []byte(string(m[1]) + "localhost:" + strconv.Itoa(local))
+	})
+	if _, err := u.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestURLRewriter(t *testing.T) {
+	defer func(saved stringList) { localForwards = saved }(localForwards)
+	localForwards = stringList{"9000:localhost:3000", "127.0.0.1:9443:127.0.0.1:443", "5432:db.internal:5432"}
+
+	tests := []struct {
+		in, want string
+	}{
+		{"open http://0.0.0.0:3000/app\n", "open http://localhost:9000/app\n"},
+		{"https://127.0.0.1:443 and http://[::1]:3000", "https://localhost:9443 and http://localhost:9000"},
+		{"http://localhost:8080/ is not forwarded", "http://localhost:8080/ is not forwarded"},
+		{"http://0.0.0.0:5432 goes elsewhere", "http://0.0.0.0:5432 goes elsewhere"},
+		{"ftp://0.0.0.0:3000 and 0.0.0.0:3000", "ftp://0.0.0.0:3000 and 0.0.0.0:3000"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		u := &urlRewriter{w: &b}
+		n, err := u.Write([]byte(tt.in))
+		if err != nil || n != len(tt.in) {
+			t.Errorf("Write(%q) = %d, %v", tt.in, n, err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("Write(%q) wrote %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+var version = flag.Bool("version", false, "print the version and build of cpu and the ssh it uses, and exit")
+
+// Returns lines describing this build of cpu from the information Go
+// embeds in binaries: the module version, the revision of the checkout
+// it was built from, and when that was committed, falling back on when
+// the binary was written for builds outside version control.  The ssh
+// cpu runs is named last, as bugs are as often in its setup.
+func versionInfo() []string {
+	mod, rev, when, dirty := "(devel)", "unknown", "", false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			mod = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				rev = s.Value
+			case "vcs.time":
+				when = s.Value
+			case "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+	}
+	if dirty {
+		rev += " (modified)"
+	}
+	if when == "" {
+		if self, err := os.Executable(); err == nil {
+			if fi, err := os.Stat(self); err == nil {
+				when = fi.ModTime().UTC().Format(time.RFC3339)
+			}
+		}
+	}
+
+	ssh := "not found"
+	if path, err := exec.LookPath("ssh"); err == nil {
+		out, _ := exec.Command(path, "-V").CombinedOutput()
+		ssh = strings.TrimSpace(string(out)) + " (" + path + ")"
+	}
+	return []string{
+		"cpu " + mod,
+		"revision " + rev,
+		"built " + when,
+		fmt.Sprintf("%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH),
+		"ssh " + ssh,
+	}
+}
+
+func printVersion() {
+	for _, line := range versionInfo() {
+		fmt.Println(line)
+	}
+}
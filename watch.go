@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sny.no/cpu/rcpu"
+)
+
+// Name of the file in the local directory listing patterns that are
+// never synchronised by -watch.
+const ignoreFile = ".cpuignore"
+
+// Size and modification time of a file, at one second granularity.
+type fileState struct {
+	size  int64
+	mtime int64
+}
+
+// Relative path -> state for every file in a synchronised tree.
+type tree map[string]fileState
+
+// A watcher keeps a local directory and a remote directory in sync in
+// both directions by polling.  The first pass takes the local
+// directory as the starting point and pushes whatever differs, as
+// -sync would, while files only on the remote are fetched.  After
+// that, files changed on only one side since the last pass are copied
+// to the other.  Files changed on both sides are conflicts and are
+// left alone until one side is made to match the other.  Deletions
+// are not propagated.  As with push, .git and files matching a
+// .gitignore are left out.
+type watcher struct {
+	local  string
+	login  string
+	remote string
+	ignore []string
+
+	// Directory -> patterns from its .gitignore, both relative to
+	// the local directory, as found by the last localTree.
+	gitignore map[string][]string
+
+	primed    bool
+	base      tree
+	conflicts map[string]bool
+}
+
+func newWatcher(local, login, remote string) *watcher {
+	return &watcher{
+		local:     local,
+		login:     login,
+		remote:    rcpu.MapPath(remote),
+		ignore:    readIgnoreFile(filepath.Join(local, ignoreFile)),
+		base:      tree{},
+		conflicts: map[string]bool{},
+	}
+}
+
+// Synchronises once, then keeps polling in the background until the
+// returned function is called, which makes a final pass.  The remote
+// directory is made first if it does not exist, so that it can be
+// listed.
+func (w *watcher) start(interval time.Duration) (stop func()) {
+	if _, err := remoteOutput(w.login, "mkdir -p "+quoteDir(w.remote)); err != nil {
+		log.Printf("watch: making %s:%s: %v", w.login, w.remote, err)
+	}
+	w.pass()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		w.run(interval, done)
+		close(finished)
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// Polls every interval until done is closed, then makes a final pass.
+func (w *watcher) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			w.pass()
+			return
+		case <-ticker.C:
+			w.pass()
+		}
+	}
+}
+
+func (w *watcher) pass() {
+	l, err := w.localTree()
+	if err != nil {
+		log.Println("watch:", err)
+		return
+	}
+	r, err := w.remoteTree()
+	if err != nil {
+		log.Println("watch:", err)
+		return
+	}
+
+	var up, down []string
+	for p, ls := range l {
+		rs, ok := r[p]
+		if ok && ls == rs {
+			w.base[p] = ls
+			delete(w.conflicts, p)
+			continue
+		}
+		bs, known := w.base[p]
+		switch {
+		case !ok && !known, !w.primed:
+			up = append(up, p)
+		case !ok:
+			// deleted remotely; leave the local copy alone
+		case known && rs == bs:
+			up = append(up, p)
+		case known && ls == bs:
+			down = append(down, p)
+		default:
+			if !w.conflicts[p] {
+				log.Printf("watch: conflict: %s changed on both sides", p)
+				w.conflicts[p] = true
+			}
+		}
+	}
+	w.primed = true
+	for p, rs := range r {
+		if _, ok := l[p]; !ok {
+			if _, known := w.base[p]; !known {
+				down = append(down, p)
+				w.base[p] = rs
+			}
+		}
+	}
+
+	if len(up) > 0 {
+		src, dst := w.local+"/", rcpu.HostPath(w.login, w.remote+"/")
+		if err := w.transfer(src, dst, up); err != nil {
+			log.Println("watch: push:", err)
+		} else {
+			for _, p := range up {
+				w.base[p] = l[p]
+			}
+		}
+	}
+	if len(down) > 0 {
+		src, dst := rcpu.HostPath(w.login, w.remote+"/"), w.local+"/"
+		if err := w.transfer(src, dst, down); err != nil {
+			log.Println("watch: pull:", err)
+		} else {
+			for _, p := range down {
+				w.base[p] = r[p]
+			}
+		}
+	}
+}
+
+// Copies the listed files from src to dst.
+func (w *watcher) transfer(src, dst string, files []string) error {
+	if verbose >= logCommands {
+		log.Printf("watch: %s -> %s: %s", src, dst, strings.Join(files, " "))
+	}
+	list, err := os.CreateTemp("", "cpu-watch")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	fmt.Fprintln(list, strings.Join(files, "\n"))
+	list.Close()
+	return rsync("--files-from="+list.Name(), src, dst)
+}
+
+func (w *watcher) localTree() (tree, error) {
+	t := tree{}
+	w.gitignore = map[string][]string{}
+	err := filepath.Walk(w.local, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(w.local, p)
+		if rel != "." && w.ignored(filepath.ToSlash(rel)) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			if pats := readIgnoreFile(filepath.Join(p, ".gitignore")); pats != nil {
+				w.gitignore[filepath.ToSlash(rel)] = pats
+			}
+		}
+		if fi.Mode().IsRegular() {
+			t[filepath.ToSlash(rel)] = fileState{fi.Size(), fi.ModTime().Unix()}
+		}
+		return nil
+	})
+	return t, err
+}
+
+func (w *watcher) remoteTree() (tree, error) {
+	find := fmt.Sprintf(`cd %s && find . -type f -printf '%%P\t%%s\t%%T@\n'`, quoteDir(w.remote))
+	out, err := remoteOutput(w.login, find)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s:%s: %v", w.login, w.remote, err)
+	}
+
+	t := tree{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 || w.ignored(fields[0]) {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		mtime, _ := strconv.ParseFloat(fields[2], 64)
+		t[fields[0]] = fileState{size, int64(mtime)}
+	}
+	return t, scanner.Err()
+}
+
+// Reports whether the slash-separated relative path p, or any of its
+// parent directories, is .git or matches a pattern from the ignore
+// file or from a .gitignore in a directory above it.
+func (w *watcher) ignored(p string) bool {
+	if p == ignoreFile {
+		return true
+	}
+	for q := p; q != "."; q = path.Dir(q) {
+		if path.Base(q) == ".git" || matchAny(w.ignore, q) {
+			return true
+		}
+		for d := path.Dir(q); ; d = path.Dir(d) {
+			rel := strings.TrimPrefix(q, d+"/")
+			if matchAny(w.gitignore[d], rel) {
+				return true
+			}
+			if d == "." {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the slash-separated path p, relative to where the
+// patterns were read, or its last element matches any of pats.  A
+// pattern with a leading slash only matches the full path.
+func matchAny(pats []string, p string) bool {
+	for _, pat := range pats {
+		if strings.HasPrefix(pat, "/") {
+			if ok, _ := path.Match(pat[1:], p); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pat, p); ok {
+			return true
+		}
+		if ok, _ := path.Match(pat, path.Base(p)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads one glob per line, skipping blank lines, # comments and the
+// ! exceptions of .gitignore, which are not supported.
+func readIgnoreFile(name string) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var pats []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		pats = append(pats, strings.TrimSuffix(line, "/"))
+	}
+	return pats
+}
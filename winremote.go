@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var remoteOS = flag.String("remote-os", os.Getenv("CPU_REMOTE_OS"),
+	"operating system of the remote: unix, windows (cmd.exe), powershell, or auto to detect")
+
+// Settles -remote-os, asking the remote's shell when it is auto.
+// cmd.exe expands %OS% to Windows_NT, and PowerShell separates its
+// output with newlines instead of leaving the echo arguments as is.
+func resolveRemoteOS(login string) {
+	switch *remoteOS {
+	case "", "unix", "windows", "powershell":
+	case "auto":
+		out, err := remoteOutput(login, "echo %OS% $PSVersionTable")
+		switch {
+		case err != nil:
+			*remoteOS = "unix"
+		case strings.HasPrefix(string(out), "Windows_NT"):
+			*remoteOS = "windows"
+		case strings.HasPrefix(string(out), "%OS%\r\n"):
+			*remoteOS = "powershell"
+		default:
+			*remoteOS = "unix"
+		}
+		explainf("remote %s appears to run %s", login, *remoteOS)
+	default:
+		exit(EX_USAGE, "unknown -remote-os %q", *remoteOS)
+	}
+	if windowsRemote() && (*readOnly || *overlay != "") {
+		exit(EX_USAGE, "-read-only and -overlay are not supported on Windows remotes")
+	}
+}
+
+func windowsRemote() bool {
+	return *remoteOS == "windows" || *remoteOS == "powershell"
+}
+
+// Crafts the command to run on a Windows remote, for cmd.exe or
+// PowerShell.
+func makeWindowsCmd(cwd string, args []string) string {
+	dir := windowsPath(cwd)
+	cmd := strings.Join(args, " ")
+	env := forwardedVars(os.Environ())
+	explainEnvironment(env)
+
+	var b strings.Builder
+	if *remoteOS == "powershell" {
+		dir = strings.Replace(dir, "%USERPROFILE%", "$env:USERPROFILE", 1)
+		fmt.Fprintf(&b, "Set-Location \"%s\"; ", dir)
+		for _, kv := range env {
+			kv := strings.SplitN(kv, "=", 2)
+			fmt.Fprintf(&b, "$env:%s = '%s'; ", kv[0], strings.ReplaceAll(kv[1], "'", "''"))
+		}
+		b.WriteString(cmd)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "cd /d \"%s\" && ", dir)
+	for _, kv := range env {
+		fmt.Fprintf(&b, "set \"%s\" && ", kv)
+	}
+	b.WriteString(cmd)
+	return b.String()
+}
+
+// Maps a remote path in Unix form to Windows form, so that ~/src
+// becomes %USERPROFILE%\src and /c/src becomes c:\src.
+func windowsPath(path string) string {
+	switch {
+	case path == "~" || strings.HasPrefix(path, "~/"):
+		path = "%USERPROFILE%" + path[1:]
+	case len(path) >= 2 && path[0] == '/' && (len(path) == 2 || path[2] == '/'):
+		path = path[1:2] + ":" + path[2:]
+	}
+	return strings.ReplaceAll(path, "/", `\`)
+}
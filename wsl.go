@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of remotes naming a local WSL distribution rather than a
+// machine reached over ssh(1): wsl:[<distribution>][:<path>].
+const wslPrefix = "wsl:"
+
+// wsl:[<distribution>][:<path>] -> distribution, path
+func splitWSL(remote string) (string, string) {
+	ss := strings.SplitN(strings.TrimPrefix(remote, wslPrefix), ":", 2)
+	if len(ss) == 2 {
+		explainf("remote %q overrides the working directory with %s", remote, ss[1])
+		return ss[0], ss[1]
+	}
+	cwd, _ := os.Getwd()
+	path := wslPath(cwd)
+	explainf("remote %q maps the working directory %s to %s", remote, cwd, path)
+	return ss[0], path
+}
+
+// Maps a Windows path to where WSL mounts it, so that C:\src becomes
+// /mnt/c/src.
+func wslPath(path string) string {
+	vol := filepath.VolumeName(path)
+	if len(vol) == 2 && vol[1] == ':' {
+		path = "/mnt/" + strings.ToLower(vol[:1]) + path[2:]
+	}
+	return filepath.ToSlash(path)
+}
+
+// Runs args under path in the WSL distribution, or in the default
+// distribution if it is empty, and returns its exit status.  The
+// distribution shares the local environment and files, so the command
+// is run as it is, without what is set up around commands sent over
+// ssh(1).
+func runWSL(distro string, path string, args []string) int {
+	args, err := expandLocal(args)
+	if err != nil {
+		exit(EX_USAGE, "%v", err)
+	}
+
+	var wslArgs []string
+	if distro != "" {
+		wslArgs = append(wslArgs, "-d", distro)
+	}
+	cmd := fmt.Sprintf("cd %s && %s", quoteDir(path), strings.Join(args, " "))
+	wslArgs = append(wslArgs, "-e", "sh", "-c", cmd)
+
+	return runCommand(exec.Command("wsl.exe", wslArgs...))
+}